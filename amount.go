@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// AmountFormat describes how the amounts in the distance columns are written, and what to do about those that cannot be
+// read
+type AmountFormat struct {
+	Decimal  string `json:"decimal"`  // Decimal separator, "." or ","; the other one separates thousands
+	Rounding string `json:"rounding"` // To whole units by "truncate" or "round", or "none" to keep fractions
+	Invalid  string `json:"invalid"`  // Empty or malformed amounts "fail" the load, "skip" their record, or "impute" the column median
+}
+
+func DefaultAmountFormat() AmountFormat {
+	return AmountFormat{Decimal: ".", Rounding: "truncate", Invalid: "fail"}
+}
+
+func (format AmountFormat) Validate() error {
+	if format.Decimal != "." && format.Decimal != "," {
+		return errors.New(fmt.Sprintf("unknown decimal separator \"%s\"", format.Decimal))
+	}
+	if format.Rounding != "truncate" && format.Rounding != "round" && format.Rounding != "none" {
+		return errors.New(fmt.Sprintf("unknown rounding \"%s\"", format.Rounding))
+	}
+	if format.Invalid != "fail" && format.Invalid != "skip" && format.Invalid != "impute" {
+		return errors.New(fmt.Sprintf("unknown policy for invalid amounts \"%s\"", format.Invalid))
+	}
+	return nil
+}
+
+// Parse reads an amount such as "$123,456.78", "123.456,78 €", "CAD 1 234" or "(500)". Currency symbols and codes,
+// spaces, apostrophes and thousands separators are ignored, and parentheses make the amount negative.
+func (format AmountFormat) Parse(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if len(s) >= 2 && s[0] == '(' && s[len(s)-1] == ')' {
+		negative = true
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
+	s = strings.TrimSpace(trimCurrencyCode(s))
+
+	decimal := rune(format.Decimal[0])
+	thousands := ','
+	if decimal == ',' {
+		thousands = '.'
+	}
+	var cleaned strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9', r == '-', r == '+':
+			cleaned.WriteRune(r)
+		case r == decimal:
+			cleaned.WriteRune('.')
+		case r == thousands, r == '\'', unicode.IsSpace(r), unicode.Is(unicode.Sc, r):
+		default:
+			return 0, errors.New(fmt.Sprintf("unexpected character '%c'", r))
+		}
+	}
+	if cleaned.Len() == 0 {
+		return 0, errors.New("no amount")
+	}
+	amount, err := strconv.ParseFloat(cleaned.String(), 64)
+	if err != nil {
+		return 0, errors.New("malformed amount")
+	}
+	if negative {
+		amount = -amount
+	}
+	return format.round(amount), nil
+}
+
+func (format AmountFormat) round(amount float64) float64 {
+	switch format.Rounding {
+	case "truncate":
+		return math.Trunc(amount)
+	case "round":
+		return math.Round(amount)
+	}
+	return amount
+}
+
+// trimCurrencyCode removes an ISO 4217 style code (three capital letters) from either end of an amount
+func trimCurrencyCode(s string) string {
+	isCode := func(code string) bool {
+		for _, r := range code {
+			if r < 'A' || r > 'Z' {
+				return false
+			}
+		}
+		return true
+	}
+	if len(s) >= 3 && isCode(s[:3]) {
+		s = s[3:]
+	}
+	if len(s) >= 3 && isCode(s[len(s)-3:]) {
+		s = s[:len(s)-3]
+	}
+	return s
+}
+
+// AmountRejection is an amount of a distance column that could not be read
+type AmountRejection struct {
+	Line   int // Line of the CSV file, where the header is line 1
+	Column string
+	Value  string
+	Reason string
+}
+
+// median returns the median of the amounts, which must not be empty
+func median(amounts []float64) float64 {
+	sorted := append([]float64(nil), amounts...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// Number of rejected amounts that are listed individually
+const maxRejectionsLogged = 10
+
+// LogRejections reports the amounts that could not be read and what was done about them
+func LogRejections(lg *log.Logger, db *Database, format AmountFormat) {
+	if len(db.Rejections) == 0 {
+		return
+	}
+	switch format.Invalid {
+	case "skip":
+		lg.Printf("Skipped %d records with %d invalid amounts\n", db.SkippedRecords, len(db.Rejections))
+	case "impute":
+		lg.Printf("Imputed %d invalid amounts with the median of their column\n", len(db.Rejections))
+	}
+	for i, rejection := range db.Rejections {
+		if i == maxRejectionsLogged {
+			lg.Printf("  ... and %d more\n", len(db.Rejections)-maxRejectionsLogged)
+			break
+		}
+		lg.Printf("  Line %d, %s \"%s\": %s\n", rejection.Line, rejection.Column, rejection.Value, rejection.Reason)
+	}
+}
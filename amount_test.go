@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		decimal  string
+		rounding string
+		text     string
+		amount   float64 // NaN when the text must be rejected
+	}{
+		{".", "truncate", "$123,456.78", 123456},
+		{".", "round", "$123,456.78", 123457},
+		{".", "none", "$123,456.78", 123456.78},
+		{".", "truncate", ".99", 0},
+		{".", "none", "CAD 1 234.5", 1234.5},
+		{".", "none", "(1,500)", -1500},
+		{".", "none", "-42", -42},
+		{".", "none", "1'000'000", 1000000},
+		{",", "none", "123.456,78 €", 123456.78},
+		{",", "round", "£ 99,5", 100},
+		{".", "none", "", math.NaN()},
+		{".", "none", "$", math.NaN()},
+		{".", "none", "N/A", math.NaN()},
+		{".", "none", "1.2.3", math.NaN()},
+		{".", "none", "12abc", math.NaN()},
+	}
+	for _, test := range tests {
+		format := AmountFormat{Decimal: test.decimal, Rounding: test.rounding, Invalid: "fail"}
+		amount, err := format.Parse(test.text)
+		if math.IsNaN(test.amount) {
+			if err == nil {
+				t.Errorf("\"%s\" was read as %v instead of being rejected", test.text, amount)
+			}
+		} else if err != nil {
+			t.Errorf("\"%s\" was rejected (%s) instead of being read as %v", test.text, err, test.amount)
+		} else if amount != test.amount {
+			t.Errorf("\"%s\" was read as %v instead of %v", test.text, amount, test.amount)
+		}
+	}
+}
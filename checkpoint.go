@@ -0,0 +1,208 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// GzipMembers is a gzip stream that can be cut into a sequence of complete gzip members. After a cut, the file up to
+// the returned offset is a valid gzip file, so a resumed scan can truncate the file there and keep appending. Readers
+// such as zcat and compress/gzip decode the concatenated members as a single stream.
+type GzipMembers struct {
+	f  *os.File
+	gz *gzip.Writer
+}
+
+func NewGzipMembers(f *os.File) *GzipMembers {
+	return &GzipMembers{f: f, gz: gzip.NewWriter(f)}
+}
+
+func (g *GzipMembers) Write(p []byte) (int, error) {
+	return g.gz.Write(p)
+}
+
+// Cut ends the current member, makes it durable, and returns the offset of the end of the file
+func (g *GzipMembers) Cut() (int64, error) {
+	if err := g.gz.Close(); err != nil {
+		return 0, err
+	}
+	if err := g.f.Sync(); err != nil {
+		return 0, err
+	}
+	offset, err := g.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	g.gz.Reset(g.f)
+	return offset, nil
+}
+
+// Close ends the last member, makes it durable, and closes the file
+func (g *GzipMembers) Close() error {
+	if err := g.gz.Close(); err != nil {
+		g.f.Close()
+		return err
+	}
+	if err := g.f.Sync(); err != nil {
+		g.f.Close()
+		return err
+	}
+	return g.f.Close()
+}
+
+// Checkpoint records the progress of a scan so that it can be resumed after a crash
+type Checkpoint struct {
+	DatabaseHash string `json:"databaseHash"`
+	ConfigHash   string `json:"configHash"`
+
+	NextContext   uint64             `json:"nextContext"`
+	FoundContexts uint64             `json:"foundContexts"`
+	OutputOffset  int64              `json:"outputOffset"`
+	Targets       []checkpointTarget `json:"targets"`
+
+	StratumMatches []uint64 `json:"stratumMatches,omitempty"` // When sampling
+}
+
+// checkpointTarget is the state of a Target, including its exponential mechanism
+type checkpointTarget struct {
+	Id              uint64      `json:"id"`
+	Matches         uint64      `json:"matches"`
+	Candidates      uint64      `json:"candidates"`
+	BestKey         *float64    `json:"bestKey"` // Null while there are no candidates
+	Selected        jsonContext `json:"selected"`
+	SelectedPopSize uint64      `json:"selectedPopSize"`
+	SelectedScore   float64     `json:"selectedScore"`
+}
+
+func CheckpointFileName(cfg *Config) string {
+	return fmt.Sprintf("%s.checkpoint", cfg.OutFile)
+}
+
+// ConfigHash identifies the parameters that affect the results of a scan
+func ConfigHash(cfg *Config) string {
+	relevant := *cfg
+	relevant.OutFile = ""
+	relevant.PrintFrequency = 0
+	relevant.CheckpointInterval = 0
+	relevant.Resume = false
+	encoded, err := json.Marshal(&relevant)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+func NewCheckpoint(db *Database, scan *Scan, next uint64, outputOffset int64) *Checkpoint {
+	cp := &Checkpoint{
+		DatabaseHash:  db.Hash(),
+		ConfigHash:    ConfigHash(scan.Cfg),
+		NextContext:   next,
+		FoundContexts: scan.Found,
+		OutputOffset:  outputOffset,
+	}
+	for _, target := range scan.Targets {
+		mechanism := target.Mechanism
+		state := checkpointTarget{
+			Id:              target.Employee.Id,
+			Matches:         target.Matches,
+			Candidates:      mechanism.Candidates,
+			Selected:        newJsonContext(mechanism.Selected),
+			SelectedPopSize: mechanism.SelectedPopSize,
+			SelectedScore:   mechanism.SelectedScore,
+		}
+		if mechanism.Candidates > 0 {
+			bestKey := mechanism.BestKey
+			state.BestKey = &bestKey
+		}
+		cp.Targets = append(cp.Targets, state)
+	}
+	if scan.Estimate != nil {
+		for _, stratum := range scan.Estimate.Strata {
+			cp.StratumMatches = append(cp.StratumMatches, stratum.Matches)
+		}
+	}
+	return cp
+}
+
+// Save atomically replaces the checkpoint file
+func (cp *Checkpoint) Save(fileName string) error {
+	tmpName := fileName + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(cp); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+func LoadCheckpoint(fileName string) (*Checkpoint, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cp := &Checkpoint{}
+	if err := json.NewDecoder(f).Decode(cp); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to parse checkpoint \"%s\": %s", fileName, err))
+	}
+	return cp, nil
+}
+
+// Restore verifies that the checkpoint belongs to the same database and parameters as the scan, then restores the scan
+// state
+func (cp *Checkpoint) Restore(db *Database, scan *Scan) error {
+	if cp.DatabaseHash != db.Hash() {
+		return errors.New("checkpoint was made with a different database")
+	}
+	if cp.ConfigHash != ConfigHash(scan.Cfg) {
+		return errors.New("checkpoint was made with a different configuration")
+	}
+	if len(cp.Targets) != len(scan.Targets) {
+		return errors.New(fmt.Sprintf("checkpoint has %d targets but the scan has %d", len(cp.Targets), len(scan.Targets)))
+	}
+	for i, target := range scan.Targets {
+		state := cp.Targets[i]
+		if state.Id != target.Employee.Id {
+			return errors.New(fmt.Sprintf("checkpoint target ID #%d does not match scan target ID #%d", state.Id, target.Employee.Id))
+		}
+		if err := state.Selected.apply(target.Mechanism.Selected); err != nil {
+			return err
+		}
+		target.Matches = state.Matches
+		target.Mechanism.Candidates = state.Candidates
+		target.Mechanism.BestKey = math.Inf(-1)
+		if state.BestKey != nil {
+			target.Mechanism.BestKey = *state.BestKey
+		}
+		target.Mechanism.SelectedPopSize = state.SelectedPopSize
+		target.Mechanism.SelectedScore = state.SelectedScore
+	}
+	if scan.Estimate != nil {
+		if len(cp.StratumMatches) != len(scan.Estimate.Strata) {
+			return errors.New("checkpoint does not match the strata of the sample")
+		}
+		for i, matches := range cp.StratumMatches {
+			scan.Estimate.Strata[i].Matches = matches
+		}
+	}
+	scan.Found = cp.FoundContexts
+	return nil
+}
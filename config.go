@@ -0,0 +1,550 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that is written to and read from JSON as a human readable string such as "30s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Config holds every tunable parameter of an experiment. It can be loaded from a JSON file and overridden on the
+// command line.
+type Config struct {
+	InFile  string `json:"inFile"`
+	OutFile string `json:"outFile"`
+	Format  string `json:"format"`
+
+	// Columns of the input file, including the initial filtering of the database
+	Schema         *Schema      `json:"schema"`
+	Amounts        AmountFormat `json:"amounts"`
+	AttributeOrder string       `json:"attributeOrder"`
+
+	// Outlier detection
+	Detector          string        `json:"detector"`
+	Metric            string        `json:"metric"`
+	MetricWeights     MetricWeights `json:"metricWeights"` // Only used by the weighted metric
+	NeighborIndex     string        `json:"neighborIndex"`
+	NeighborCache     string        `json:"neighborCache"` // File holding the table index between runs
+	K                 uint64        `json:"k"`
+	Ties              string        `json:"ties"`             // Neighbors tied with the k-th: "index" keeps those of lowest index, "inclusive" all
+	SmallPopulations  string        `json:"smallPopulations"` // Subsets of at most k records: "adapt" their neighborhoods, or "skip" them
+	OutlierThreshold  float64       `json:"outlierThreshold"` // Zero for the default of the detector
+	MinPopulationSize uint64        `json:"minPopulationSize"`
+	HistogramBins     uint          `json:"histogramBins"` // Only used by the histogram detector
+
+	// Original context, with an entry for every attribute of the schema
+	Original []OriginalAttribute `json:"original"`
+
+	// Outlier to explain, by record ID (when non-negative) or by rank of LOF score (when non-zero)
+	TargetId   int64 `json:"targetId"`
+	TargetRank uint  `json:"targetRank"`
+	// Explain every outlier of the original context in a single scan
+	AllOutliers bool `json:"allOutliers"`
+
+	// Private release
+	Epsilon float64 `json:"epsilon"`
+	Utility string  `json:"utility"`
+
+	PrintFrequency Duration `json:"printFrequency"`
+
+	// Order of the superset enumeration, how it is searched, and the part of it scanned by this process, numbered from 0
+	Enumeration string `json:"enumeration"`
+	Search      string `json:"search"`
+	Samples     uint64 `json:"samples"` // Number of contexts drawn by the sampling searches
+	SampleSeed  int64  `json:"sampleSeed"`
+	Shard       uint   `json:"shard"`
+	Shards      uint   `json:"shards"`
+
+	// Periodic checkpoints of the scan (disabled when zero) and resumption from the last one
+	CheckpointInterval Duration `json:"checkpointInterval"`
+	Resume             bool     `json:"resume"`
+
+	minRecords map[string]uint // Given by flags, which apply to the final schema
+}
+
+// OriginalAttribute gives the values of an attribute in the original context, either by name or as the first Count
+// values of the attribute
+type OriginalAttribute struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values,omitempty"`
+	Count  uint     `json:"count,omitempty"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Format:            "text",
+		Schema:            DefaultSchema(),
+		Amounts:           DefaultAmountFormat(),
+		AttributeOrder:    string(SortedOrder),
+		Detector:          "lof",
+		Metric:            "absolute",
+		NeighborIndex:     "table",
+		K:                 20,
+		Ties:              "index",
+		SmallPopulations:  "adapt",
+		MinPopulationSize: 20,
+		HistogramBins:     10,
+		Original: []OriginalAttribute{
+			{Name: "Employer", Count: 6},
+			{Name: "Job Title", Count: 5},
+			{Name: "Calendar Year", Count: 5},
+		},
+		TargetId:           -1,
+		Epsilon:            0.1,
+		Utility:            "popsize",
+		PrintFrequency:     Duration(time.Second * 30),
+		Enumeration:        "gray",
+		Search:             "exhaustive",
+		Samples:            100000,
+		SampleSeed:         1,
+		Shards:             1,
+		CheckpointInterval: Duration(time.Minute * 10),
+	}
+}
+
+// ParseConfig builds the configuration from the command line. Values are taken from the defaults, then from the
+// configuration file named by -config (if any), then from the flags that were explicitly given. The positional
+// arguments INFILE and OUTFILE override the file as well.
+func ParseConfig(args []string) (*Config, error) {
+	cfg, fs, err := parseFlags(args, "[INFILE OUTFILE]")
+	if err != nil {
+		return nil, err
+	}
+
+	switch fs.NArg() {
+	case 0:
+	case 2:
+		cfg.InFile = fs.Arg(0)
+		cfg.OutFile = fs.Arg(1)
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if cfg.InFile == "" || cfg.OutFile == "" {
+		return nil, errors.New("input and output files must be specified")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseFlags applies the configuration file and the flags, leaving the positional arguments to the caller
+func parseFlags(args []string, positional string) (*Config, *flag.FlagSet, error) {
+	var configFile string
+	cfg := DefaultConfig()
+	fs := cfg.flagSet(args[0], positional, &configFile)
+	fs.Parse(args[1:])
+
+	// The flags are parsed a second time on top of the configuration file so that they take precedence
+	if configFile != "" {
+		cfg = DefaultConfig()
+		if err := cfg.load(configFile); err != nil {
+			return nil, nil, err
+		}
+		fs = cfg.flagSet(args[0], positional, &configFile)
+		fs.Parse(args[1:])
+	}
+
+	// Minimum record counts override whichever schema was given
+	for column, minRecords := range cfg.minRecords {
+		i := cfg.Schema.Attribute(column)
+		if i < 0 {
+			return nil, nil, errors.New(fmt.Sprintf("minimum record count of unknown attribute \"%s\"", column))
+		}
+		cfg.Schema.Attributes[i].MinRecords = minRecords
+	}
+	return cfg, fs, nil
+}
+
+func (cfg *Config) flagSet(name string, positional string, configFile *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [OPTIONS] %s\n", name, positional)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(configFile, "config", *configFile, "JSON configuration file (flags take precedence over its values)")
+	fs.StringVar(&cfg.Format, "format", cfg.Format, "output format (text, jsonl)")
+	fs.Var(&schemaFlag{cfg: cfg}, "schema", "JSON file describing the columns of the input file, which clears the original context (default: Ontario salary disclosure)")
+	fs.Var(&minRecordsFlag{cfg: cfg}, "min-records", "ATTRIBUTE=N: minimum number of records for a value of the attribute to be kept (repeatable)")
+	fs.StringVar(&cfg.Amounts.Decimal, "decimal", cfg.Amounts.Decimal, "decimal separator of the amounts in the distance columns (., ,)")
+	fs.StringVar(&cfg.Amounts.Rounding, "rounding", cfg.Amounts.Rounding, "rounding of the amounts to whole units (truncate, round, none)")
+	fs.StringVar(&cfg.Amounts.Invalid, "invalid-amounts", cfg.Amounts.Invalid, "empty or malformed amounts (fail; skip: drop the record; impute: use the median of the column)")
+	fs.StringVar(&cfg.AttributeOrder, "attribute-order", cfg.AttributeOrder, "indexing of attribute values (sorted, appearance)")
+	fs.StringVar(&cfg.Detector, "detector", cfg.Detector, "outlier detector (lof; zscore: largest deviation of an amount from the mean, in standard deviations; mad: the same from the median, in median absolute deviations; knn: distance to the k-th nearest neighbor; histogram: rarity of the amounts in histograms of the context)")
+	fs.StringVar(&cfg.Metric, "metric", cfg.Metric, "distance metric between records (absolute, logratio: for amounts of at least 0, weighted)")
+	fs.Var(&weightFlag{weights: &cfg.MetricWeights}, "weight", "NAME=W: weighted metric: weight per unit of difference of a distance column (default 1) or numeric attribute, or distance added between different values of a categorical attribute (repeatable)")
+	fs.StringVar(&cfg.NeighborIndex, "neighbor-index", cfg.NeighborIndex, "nearest neighbor index (table: any metric, O(n^2) memory; sorted: absolute or logratio metric, O(n) memory)")
+	fs.StringVar(&cfg.NeighborCache, "neighbor-cache", cfg.NeighborCache, "file to load the table index from, or to save it to if the file does not exist")
+	fs.Uint64Var(&cfg.K, "k", cfg.K, "number of nearest neighbors used by LOF")
+	fs.StringVar(&cfg.Ties, "ties", cfg.Ties, "neighbors at the same distance as the k-th nearest (index: keep exactly k, breaking ties by record order; inclusive: keep them all, as in the definition of LOF)")
+	fs.StringVar(&cfg.SmallPopulations, "small-populations", cfg.SmallPopulations, "contexts of at most k records (adapt: neighborhoods of all the other records; skip: no outliers)")
+	fs.Float64Var(&cfg.OutlierThreshold, "threshold", cfg.OutlierThreshold, "minimum score of an outlier (default: 1.5 for lof, 3 for zscore, 3.5 for mad, ln 10 for histogram; knn has no default, as its scores are in units of the metric)")
+	fs.Uint64Var(&cfg.MinPopulationSize, "min-population", cfg.MinPopulationSize, "contexts with fewer records are never searched for outliers")
+	fs.UintVar(&cfg.HistogramBins, "bins", cfg.HistogramBins, "number of bins per distance column of the histogram detector")
+	fs.Var(&origFlag{cfg: cfg, set: make(map[string]bool)}, "orig", "ATTRIBUTE=VALUE: value in the original context (repeatable; overrides -orig-count for the attribute)")
+	fs.Var(&origCountFlag{cfg: cfg}, "orig-count", "ATTRIBUTE=N: the original context has the first N values of the attribute (repeatable)")
+	fs.Int64Var(&cfg.TargetId, "target-id", cfg.TargetId, "ID of the outlier to explain (default: the first outlier found)")
+	fs.UintVar(&cfg.TargetRank, "target-rank", cfg.TargetRank, "rank by score of the outlier to explain, starting at 1 (default: the first outlier found)")
+	fs.BoolVar(&cfg.AllOutliers, "all-outliers", cfg.AllOutliers, "explain every outlier of the original context in a single scan")
+	fs.Float64Var(&cfg.Epsilon, "epsilon", cfg.Epsilon, "privacy parameter of the exponential mechanism")
+	fs.StringVar(&cfg.Utility, "utility", cfg.Utility, "utility function of the exponential mechanism (popsize, uniform)")
+	fs.DurationVar((*time.Duration)(&cfg.PrintFrequency), "print-frequency", time.Duration(cfg.PrintFrequency), "interval between progress messages")
+	fs.StringVar(&cfg.Enumeration, "enumeration", cfg.Enumeration, "order of the superset scan (gray: one attribute value changes between consecutive contexts; counter)")
+	fs.StringVar(&cfg.Search, "search", cfg.Search, "superset search (exhaustive; pruned: skip groups of supersets in which no target can be an outlier; sample: uniform random supersets; stratified: random supersets, equally many of each number of added attribute values)")
+	fs.Uint64Var(&cfg.Samples, "samples", cfg.Samples, "number of supersets drawn (without replacement) by the sample and stratified searches")
+	fs.Int64Var(&cfg.SampleSeed, "sample-seed", cfg.SampleSeed, "seed of the supersets drawn by the sample and stratified searches")
+	fs.UintVar(&cfg.Shard, "shard", cfg.Shard, "index of the shard of the scan to run, starting at 0")
+	fs.UintVar(&cfg.Shards, "shards", cfg.Shards, "number of shards the scan is split into (shard outputs are combined with the merge command)")
+	fs.DurationVar((*time.Duration)(&cfg.CheckpointInterval), "checkpoint-interval", time.Duration(cfg.CheckpointInterval), "interval between checkpoints of the scan (0 to disable)")
+	fs.BoolVar(&cfg.Resume, "resume", cfg.Resume, "resume the scan from the checkpoint next to the output file")
+	return fs
+}
+
+// splitAssignment splits a flag value of the form NAME=VALUE
+func splitAssignment(value string) (string, string, error) {
+	i := strings.Index(value, "=")
+	if i < 0 {
+		return "", "", errors.New(fmt.Sprintf("\"%s\" is not of the form NAME=VALUE", value))
+	}
+	return value[:i], value[i+1:], nil
+}
+
+// schemaFlag loads the schema from a file. The original context is cleared, since it names the attributes of the
+// previous schema.
+type schemaFlag struct {
+	cfg *Config
+}
+
+func (f *schemaFlag) String() string {
+	return ""
+}
+
+func (f *schemaFlag) Set(value string) error {
+	schema, err := LoadSchema(value)
+	if err != nil {
+		return err
+	}
+	f.cfg.Schema = schema
+	f.cfg.Original = nil
+	return nil
+}
+
+// minRecordsFlag collects the minimum record counts of attributes, which parseFlags applies to the schema
+type minRecordsFlag struct {
+	cfg *Config
+}
+
+func (f *minRecordsFlag) String() string {
+	return ""
+}
+
+func (f *minRecordsFlag) Set(value string) error {
+	column, countStr, err := splitAssignment(value)
+	if err != nil {
+		return err
+	}
+	count, err := strconv.ParseUint(countStr, 10, 0)
+	if err != nil {
+		return errors.New(fmt.Sprintf("invalid record count \"%s\"", countStr))
+	}
+	if f.cfg.minRecords == nil {
+		f.cfg.minRecords = make(map[string]uint)
+	}
+	f.cfg.minRecords[column] = uint(count)
+	return nil
+}
+
+// weightFlag collects metric weights. Weights given on the command line replace (rather than extend) the weights loaded
+// from the configuration file.
+type weightFlag struct {
+	weights *MetricWeights
+	set     bool
+}
+
+func (f *weightFlag) String() string {
+	if f.weights == nil {
+		return ""
+	}
+	var weights []string
+	for name, weight := range *f.weights {
+		weights = append(weights, fmt.Sprintf("%s=%g", name, weight))
+	}
+	sort.Strings(weights)
+	return strings.Join(weights, "; ")
+}
+
+func (f *weightFlag) Set(value string) error {
+	name, weightStr, err := splitAssignment(value)
+	if err != nil {
+		return err
+	}
+	weight, err := strconv.ParseFloat(weightStr, 64)
+	if err != nil {
+		return errors.New(fmt.Sprintf("invalid weight \"%s\"", weightStr))
+	}
+	if !f.set {
+		*f.weights = make(MetricWeights)
+		f.set = true
+	}
+	(*f.weights)[name] = weight
+	return nil
+}
+
+// original returns the original context entry of an attribute, adding it if needed
+func (cfg *Config) original(name string) *OriginalAttribute {
+	for i := range cfg.Original {
+		if cfg.Original[i].Name == name {
+			return &cfg.Original[i]
+		}
+	}
+	cfg.Original = append(cfg.Original, OriginalAttribute{Name: name})
+	return &cfg.Original[len(cfg.Original)-1]
+}
+
+// origFlag collects the values of attributes in the original context. Values given on the command line replace (rather
+// than extend) those of the same attribute loaded from the configuration file.
+type origFlag struct {
+	cfg *Config
+	set map[string]bool
+}
+
+func (f *origFlag) String() string {
+	return ""
+}
+
+func (f *origFlag) Set(value string) error {
+	name, attributeValue, err := splitAssignment(value)
+	if err != nil {
+		return err
+	}
+	entry := f.cfg.original(name)
+	if !f.set[name] {
+		entry.Values = nil
+		f.set[name] = true
+	}
+	entry.Values = append(entry.Values, attributeValue)
+	return nil
+}
+
+// origCountFlag sets the number of values of an attribute in the original context
+type origCountFlag struct {
+	cfg *Config
+}
+
+func (f *origCountFlag) String() string {
+	return ""
+}
+
+func (f *origCountFlag) Set(value string) error {
+	name, countStr, err := splitAssignment(value)
+	if err != nil {
+		return err
+	}
+	count, err := strconv.ParseUint(countStr, 10, 0)
+	if err != nil {
+		return errors.New(fmt.Sprintf("invalid value count \"%s\"", countStr))
+	}
+	entry := f.cfg.original(name)
+	entry.Values = nil
+	entry.Count = uint(count)
+	return nil
+}
+
+func (cfg *Config) load(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to open configuration file: %s", err))
+	}
+	defer f.Close()
+
+	// The schema and the original context would be decoded into the defaults element by element, keeping the default
+	// fields that the file leaves out, so they are only restored when the file does not give them. Like -schema, a
+	// schema clears the default original context.
+	schema, original := cfg.Schema, cfg.Original
+	cfg.Schema, cfg.Original = nil, nil
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return errors.New(fmt.Sprintf("failed to parse configuration file \"%s\": %s", fileName, err))
+	}
+	if cfg.Schema == nil {
+		cfg.Schema = schema
+		if cfg.Original == nil {
+			cfg.Original = original
+		}
+	}
+	return nil
+}
+
+// Validate checks the parameters that do not depend on the database
+func (cfg *Config) Validate() error {
+	if cfg.Format != "text" && cfg.Format != "jsonl" {
+		return errors.New(fmt.Sprintf("unknown output format \"%s\"", cfg.Format))
+	}
+	if cfg.Schema == nil {
+		return errors.New("schema must be specified")
+	}
+	if err := cfg.Schema.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Amounts.Validate(); err != nil {
+		return err
+	}
+	if !AttributeOrder(cfg.AttributeOrder).Valid() {
+		return errors.New(fmt.Sprintf("unknown attribute order \"%s\"", cfg.AttributeOrder))
+	}
+	if cfg.Metric != "absolute" && cfg.Metric != "logratio" && cfg.Metric != "weighted" {
+		return errors.New(fmt.Sprintf("unknown metric \"%s\"", cfg.Metric))
+	}
+	for name, weight := range cfg.MetricWeights {
+		if weight < 0 {
+			return errors.New("metric weights cannot be negative")
+		}
+		if cfg.Schema.Attribute(name) < 0 && indexOf(cfg.Schema.Distances, name) < 0 {
+			return errors.New(fmt.Sprintf("metric weight of unknown attribute \"%s\"", name))
+		}
+	}
+	switch cfg.Detector {
+	case "lof", "zscore", "mad", "knn", "histogram":
+	default:
+		return errors.New(fmt.Sprintf("unknown outlier detector \"%s\"", cfg.Detector))
+	}
+	if cfg.Detector == "histogram" && cfg.HistogramBins < 1 {
+		return errors.New("the histogram detector needs at least one bin")
+	}
+	if cfg.NeighborIndex != "table" && cfg.NeighborIndex != "sorted" {
+		return errors.New(fmt.Sprintf("unknown neighbor index \"%s\"", cfg.NeighborIndex))
+	}
+	if cfg.NeighborIndex == "sorted" && len(cfg.Schema.Distances) != 1 {
+		return errors.New("the sorted neighbor index needs a single distance column")
+	}
+	if cfg.K < 1 {
+		return errors.New("k must be at least 1")
+	}
+	if cfg.Ties != "index" && cfg.Ties != "inclusive" {
+		return errors.New(fmt.Sprintf("unknown tie handling \"%s\"", cfg.Ties))
+	}
+	if cfg.SmallPopulations != "adapt" && cfg.SmallPopulations != "skip" {
+		return errors.New(fmt.Sprintf("unknown handling of small populations \"%s\"", cfg.SmallPopulations))
+	}
+	if cfg.MinPopulationSize < 2 {
+		return errors.New("minimum population size must be at least 2")
+	}
+	if !(cfg.OutlierThreshold >= 0) {
+		return errors.New("outlier threshold cannot be negative")
+	}
+	if cfg.Threshold() == 0 {
+		return errors.New(fmt.Sprintf("the %s detector has no default outlier threshold (set -threshold)", cfg.Detector))
+	}
+	for _, entry := range cfg.Original {
+		if cfg.Schema.Attribute(entry.Name) < 0 {
+			return errors.New(fmt.Sprintf("original context has values of unknown attribute \"%s\"", entry.Name))
+		}
+	}
+	for _, attribute := range cfg.Schema.Attributes {
+		found := 0
+		for _, entry := range cfg.Original {
+			if entry.Name == attribute.Column {
+				found++
+			}
+		}
+		if found != 1 {
+			return errors.New(fmt.Sprintf("original context must give the values of attribute \"%s\" once", attribute.Column))
+		}
+	}
+	if cfg.TargetId >= 0 && cfg.TargetRank > 0 {
+		return errors.New("the outlier to explain can be given by ID or by rank, but not both")
+	}
+	if cfg.AllOutliers && (cfg.TargetId >= 0 || cfg.TargetRank > 0) {
+		return errors.New("a single outlier to explain cannot be given when explaining all outliers")
+	}
+	if !(cfg.Epsilon > 0) {
+		return errors.New("epsilon must be positive")
+	}
+	if _, ok := Utilities[cfg.Utility]; !ok {
+		return errors.New(fmt.Sprintf("unknown utility function \"%s\"", cfg.Utility))
+	}
+	if cfg.PrintFrequency <= 0 {
+		return errors.New("print frequency must be positive")
+	}
+	if cfg.Enumeration != "gray" && cfg.Enumeration != "counter" {
+		return errors.New(fmt.Sprintf("unknown enumeration \"%s\"", cfg.Enumeration))
+	}
+	if cfg.Search != "exhaustive" && cfg.Search != "pruned" && cfg.Search != "sample" && cfg.Search != "stratified" {
+		return errors.New(fmt.Sprintf("unknown search \"%s\"", cfg.Search))
+	}
+	if cfg.Search == "pruned" && cfg.Detector != "lof" {
+		return errors.New("the pruned search bounds LOF scores, so it needs the lof detector")
+	}
+	if cfg.Samples < 1 {
+		return errors.New("at least one sample must be drawn")
+	}
+	if cfg.Shards < 1 || cfg.Shard >= cfg.Shards {
+		return errors.New(fmt.Sprintf("shard %d does not exist among %d shards", cfg.Shard, cfg.Shards))
+	}
+	if cfg.Shards > 1 && cfg.Format != "jsonl" {
+		return errors.New("sharded scans must use the jsonl output format so that they can be merged")
+	}
+	if cfg.CheckpointInterval < 0 {
+		return errors.New("checkpoint interval cannot be negative")
+	}
+	return nil
+}
+
+// Threshold returns the minimum score of an outlier, which defaults to that of the detector
+func (cfg *Config) Threshold() float64 {
+	if cfg.OutlierThreshold > 0 {
+		return cfg.OutlierThreshold
+	}
+	return DefaultThreshold(cfg.Detector)
+}
+
+// ValidateDatabase checks the parameters that depend on the loaded database
+func (cfg *Config) ValidateDatabase(db *Database) error {
+	if DetectorNeedsNeighbors(cfg.Detector) && uint64(len(db.Employees)) <= cfg.K {
+		return errors.New(fmt.Sprintf("database has %d records, which is not more than k = %d", len(db.Employees), cfg.K))
+	}
+	// Named attribute values are checked when the original context is resolved
+	for _, entry := range cfg.Original {
+		values := len(db.Attributes[db.Attribute(entry.Name)].Values)
+		if len(entry.Values) == 0 && entry.Count > uint(values) {
+			return errors.New(fmt.Sprintf("original context needs %d values of %s but the database only has %d", entry.Count, entry.Name, values))
+		}
+	}
+	return nil
+}
+
+// WriteJSON records the effective configuration as indented JSON
+func (cfg *Config) WriteJSON(w io.Writer) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(cfg)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestThresholdDefaultsByDetector(t *testing.T) {
+	tests := []struct {
+		detector  string
+		threshold float64 // As configured
+		want      float64 // 0 when the configuration is invalid
+	}{
+		{"lof", 0, 1.5},
+		{"zscore", 0, 3},
+		{"mad", 0, 3.5},
+		{"histogram", 0, DefaultThreshold("histogram")},
+		{"knn", 0, 0},
+		{"lof", 2, 2},
+		{"zscore", 2, 2},
+		{"knn", 25000, 25000},
+	}
+	for _, test := range tests {
+		cfg := DefaultConfig()
+		cfg.Detector = test.detector
+		cfg.OutlierThreshold = test.threshold
+		err := cfg.Validate()
+		if test.want == 0 {
+			if err == nil {
+				t.Errorf("the %s detector without a threshold is accepted", test.detector)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("the %s detector with a threshold of %v is rejected: %s", test.detector, test.threshold, err)
+		} else if got := cfg.Threshold(); got != test.want {
+			t.Errorf("the %s detector with a threshold of %v has a threshold of %v, expected %v", test.detector, test.threshold, got, test.want)
+		}
+	}
+}
+
+func TestConfigFileReplacesDefaults(t *testing.T) {
+	tests := []struct {
+		json     string
+		schema   *Schema
+		original []OriginalAttribute
+	}{
+		{`{"k": 5}`, DefaultSchema(), DefaultConfig().Original},
+		{
+			`{"original": [{"name": "Employer", "values": ["A"]}, {"name": "Job Title", "count": 2}, {"name": "Calendar Year", "count": 1}]}`,
+			DefaultSchema(),
+			[]OriginalAttribute{{Name: "Employer", Values: []string{"A"}}, {Name: "Job Title", Count: 2}, {Name: "Calendar Year", Count: 1}},
+		},
+		{
+			`{"schema": {"attributes": [{"column": "Sector"}, {"column": "Province"}, {"column": "Sex"}], "distances": ["Income"]}}`,
+			&Schema{Attributes: []SchemaAttribute{{Column: "Sector"}, {Column: "Province"}, {Column: "Sex"}}, Distances: []string{"Income"}},
+			nil,
+		},
+		{
+			`{"schema": {"attributes": [{"column": "Sector"}, {"column": "Province"}], "distances": ["Income"]},
+			  "original": [{"name": "Sector", "values": ["Health"]}, {"name": "Province"}]}`,
+			&Schema{Attributes: []SchemaAttribute{{Column: "Sector"}, {Column: "Province"}}, Distances: []string{"Income"}},
+			[]OriginalAttribute{{Name: "Sector", Values: []string{"Health"}}, {Name: "Province"}},
+		},
+	}
+	for _, test := range tests {
+		fileName := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(fileName, []byte(test.json), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg := DefaultConfig()
+		if err := cfg.load(fileName); err != nil {
+			t.Fatalf("%s: %s", test.json, err)
+		}
+		if !reflect.DeepEqual(cfg.Schema, test.schema) {
+			t.Errorf("%s: the schema is %+v, expected %+v", test.json, *cfg.Schema, *test.schema)
+		}
+		if !reflect.DeepEqual(cfg.Original, test.original) {
+			t.Errorf("%s: the original context is %+v, expected %+v", test.json, cfg.Original, test.original)
+		}
+	}
+}
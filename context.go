@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+)
+
+// Context is a subset of the database given by the included values of each attribute
+type Context struct {
+	Names    []string // Names of the attributes
+	Included [][]bool // Inclusion of each value, by attribute
+}
+
+func NewContext(db *Database) *Context {
+	ctx := &Context{
+		Names:    make([]string, len(db.Attributes)),
+		Included: make([][]bool, len(db.Attributes)),
+	}
+	for i, attribute := range db.Attributes {
+		ctx.Names[i] = attribute.Name
+		ctx.Included[i] = make([]bool, len(attribute.Values))
+	}
+	return ctx
+}
+
+func (ctx *Context) Copy(other *Context) {
+	for dimension, included := range other.Included {
+		copy(ctx.Included[dimension], included)
+	}
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// countIncluded returns the number of attribute values included in a context
+func countIncluded(arr []bool) int {
+	count := 0
+	for _, included := range arr {
+		if included {
+			count++
+		}
+	}
+	return count
+}
+
+// Summary describes the number of included values of each attribute
+func (ctx *Context) Summary() string {
+	parts := make([]string, len(ctx.Included))
+	for dimension, included := range ctx.Included {
+		parts[dimension] = fmt.Sprintf("%d %s values", countIncluded(included), ctx.Names[dimension])
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (ctx *Context) WriteTo(w io.Writer) {
+	dumpIncluded := func(arr []bool) {
+		fmt.Fprintf(w, "[")
+		first := true
+		for i, included := range arr {
+			if !included {
+				continue
+			}
+			if first {
+				first = false
+			} else {
+				fmt.Fprint(w, ", ")
+			}
+			fmt.Fprintf(w, "%d", i)
+		}
+		fmt.Fprintln(w, "]")
+	}
+
+	fmt.Fprintln(w, "{")
+	for dimension, included := range ctx.Included {
+		fmt.Fprintf(w, "  %s: ", ctx.Names[dimension])
+		dumpIncluded(included)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// OriginalContext creates the original context of the configuration. Attributes that were not given by value include
+// their first values.
+func OriginalContext(cfg *Config, db *Database) (*Context, error) {
+	ctx := NewContext(db)
+	for _, entry := range cfg.Original {
+		dimension := db.Attribute(entry.Name)
+		if dimension < 0 {
+			return nil, errors.New(fmt.Sprintf("unknown attribute \"%s\"", entry.Name))
+		}
+		for _, value := range entry.Values {
+			i, err := db.ValueIndex(dimension, value)
+			if err != nil {
+				return nil, err
+			}
+			ctx.Included[dimension][i] = true
+		}
+		if len(entry.Values) == 0 {
+			for i := uint(0); i < entry.Count; i++ {
+				ctx.Included[dimension][i] = true
+			}
+		}
+	}
+	return ctx, nil
+}
+
+// FilterContext fills an inclusion mask with the records of a context. A record is included when, in every dimension,
+// it has one of the included values, so the bitsets of the values are combined word by word with OR within a dimension
+// and AND across dimensions.
+func FilterContext(db *Database, im *InclusionMask, ctx *Context) {
+	im.Count = 0
+	for w := range im.Mask {
+		word := ^uint64(0)
+		for dimension, included := range ctx.Included {
+			var values uint64
+			for value, isIncluded := range included {
+				if isIncluded {
+					values |= db.ValueMasks[dimension][value][w]
+				}
+			}
+			word &= values
+		}
+		im.Mask[w] = word
+		im.Count += uint64(bits.OnesCount64(word))
+	}
+}
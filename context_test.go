@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+// filterRecords fills an inclusion mask with the records of a context by the original record by record filter, which
+// streams whether each record is in the context
+func filterRecords(db *Database, im *InclusionMask, ctx *Context) {
+	inclusion := make(chan bool)
+	go func() {
+		for _, employee := range db.Employees {
+			include := true
+			for dimension, value := range employee.Attributes {
+				if !ctx.Included[dimension][value] {
+					include = false
+					break
+				}
+			}
+			inclusion <- include
+		}
+		close(inclusion)
+	}()
+
+	var i uint64
+	im.Count = 0
+	for include := range inclusion {
+		if i%64 == 0 {
+			im.Mask[i/64] = 0
+		}
+		if include {
+			im.Mask[i/64] |= 1 << (i % 64)
+			im.Count++
+		}
+		i++
+	}
+}
+
+func TestFilterContext(t *testing.T) {
+	env := newTestEnv(t, DefaultSyntheticSpec(), testConfig())
+	bitsetIm := NewInclusionMask(env.Db)
+	recordIm := NewInclusionMask(env.Db)
+	env.forEachContext(func(ctx *Context) {
+		FilterContext(env.Db, bitsetIm, ctx)
+		filterRecords(env.Db, recordIm, ctx)
+		if bitsetIm.Count != recordIm.Count {
+			t.Fatalf("bitset filtering includes %d records but record by record filtering includes %d", bitsetIm.Count, recordIm.Count)
+		}
+		for w := range bitsetIm.Mask {
+			if bitsetIm.Mask[w] != recordIm.Mask[w] {
+				t.Fatalf("bitset filtering differs from record by record filtering in records %d to %d", w*64, w*64+63)
+			}
+		}
+	})
+}
+
+// benchmarkFilter filters the supersets of the original context in scan order
+func benchmarkFilter(b *testing.B, filter func(db *Database, im *InclusionMask, ctx *Context)) {
+	env := newTestEnv(b, DefaultSyntheticSpec(), testConfig())
+	scan := NewScan(env.Db, env.Lof, env.Cfg, env.Original, nil, nil)
+	ctx := NewContext(env.Db)
+	im := NewInclusionMask(env.Db)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		scan.SetContext(ctx, uint64(n)%scan.TotalContexts())
+		filter(env.Db, im, ctx)
+	}
+}
+
+func BenchmarkFilterContext(b *testing.B) {
+	benchmarkFilter(b, FilterContext)
+}
+
+func BenchmarkDatabaseFilter(b *testing.B) {
+	benchmarkFilter(b, filterRecords)
+}
@@ -1,87 +1,114 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/csv"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"sort"
 	"strconv"
-	"strings"
 )
 
-type Distance uint32
+type Distance float64
+
+// AttributeOrder determines how the values of each filtering attribute are indexed
+type AttributeOrder string
+
+const (
+	SortedOrder     AttributeOrder = "sorted"     // Lexicographic (numeric for numeric attributes)
+	AppearanceOrder AttributeOrder = "appearance" // Order of first appearance in the input file
+)
+
+func (order AttributeOrder) Valid() bool {
+	return order == SortedOrder || order == AppearanceOrder
+}
 
 type Database struct {
-	Employers []string
-	JobTitles []string
-	Years     []uint16
+	Attributes []*Attribute
+	Distances  []string // Names of the distance columns, as indexed by Employee.Distances
 
 	Employees []*Employee
+
+	// Bitsets of the records with each attribute value, by dimension as in Context.Included and by value
+	ValueMasks [][][]uint64
+
+	// Amounts that could not be read, and the number of records skipped because of them
+	Rejections     []AmountRejection
+	SkippedRecords int
+}
+
+// Attribute is a categorical column that contexts filter on
+type Attribute struct {
+	Name    string
+	Values  []string
+	Numbers []float64 // Numeric value of each value, for numeric attributes
+
+	// Values removed by initial filtering, mapped to their number of records
+	Excluded map[string]uint
 }
 
+// Employee is a record of the database
 type Employee struct {
 	Id uint64
 
-	// View filtering attributes
-	Employer uint
-	JobTitle uint
-	Year     uint
+	// View filtering attributes, as indices into the values of each attribute
+	Attributes []uint
 
 	// Distance attributes
-	Salary uint32
+	Distances []float64
 }
 
-func (e *Employee) Distance(other *Employee) Distance {
-	if e.Salary < other.Salary {
-		return Distance(other.Salary - e.Salary)
-	} else {
-		return Distance(e.Salary - other.Salary)
-	}
-}
-
-func ReadDatabase(r io.Reader, minPerEmployer uint, minPerJobTitle uint) (*Database, error) {
-	db := &Database{}
+// ReadDatabase loads the records from a CSV file with the columns described by the schema, keeping only attribute
+// values with enough records. Amounts are read according to amounts, including its policy for invalid amounts. The
+// attribute values are indexed deterministically according to order, so the same input always yields the same indices.
+func ReadDatabase(r io.Reader, schema *Schema, amounts AmountFormat, order AttributeOrder) (*Database, error) {
+	db := &Database{Distances: append([]string(nil), schema.Distances...)}
 
 	in := csv.NewReader(r)
 
-	// Column indices in the CSV for the fields of interest
-	idCol := -1
-	employerCol := -1
-	jobTitleCol := -1
-	salaryCol := -1
-	yearCol := -1
-
 	// Tracking for unique values that can be used as selection filters
-	employerSet := make(map[string]uint) // Maps value -> initial array index
-	jobTitleSet := make(map[string]uint)
-	yearSet := make(map[uint16]uint)
-
-	// Counters for members of classes (for initial filtering)
-	employerEmployees := make([]uint, 0) // Maps array index -> number of records (for filtering)
-	jobTitleEmployees := make([]uint, 0)
+	type attributeValues struct {
+		set     map[string]uint // Maps value -> initial array index
+		names   []string        // Maps initial array index -> value
+		records []uint          // Maps initial array index -> number of records (for filtering)
+	}
+	values := make([]*attributeValues, len(schema.Attributes))
+	for i := range values {
+		values[i] = &attributeValues{set: make(map[string]uint)}
+	}
 
 	// Figure out where the columns are
 	header, err := in.Read()
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("failed to read CSV header: %s", err))
 	}
-	for columnNum, columnName := range header {
-		switch columnName {
-		case "":
-			idCol = columnNum
-		case "Employer":
-			employerCol = columnNum
-		case "Job Title":
-			jobTitleCol = columnNum
-		case "Salary Paid":
-			salaryCol = columnNum
-		case "Calendar Year":
-			yearCol = columnNum
+	column := func(name string) (int, error) {
+		for columnNum, columnName := range header {
+			if columnName == name {
+				return columnNum, nil
+			}
 		}
-		// If you update this switch, don't forget to update the completeness check below
+		return -1, errors.New(fmt.Sprintf("column \"%s\" is missing from the CSV header", name))
 	}
-	if idCol < 0 || employerCol < 0 || jobTitleCol < 0 || salaryCol < 0 || yearCol < 0 {
-		return nil, errors.New("some expected columns were missing from the CSV header")
+	idCol, err := column(schema.Id)
+	if err != nil {
+		return nil, err
+	}
+	attributeCols := make([]int, len(schema.Attributes))
+	for i, attribute := range schema.Attributes {
+		if attributeCols[i], err = column(attribute.Column); err != nil {
+			return nil, err
+		}
+	}
+	distanceCols := make([]int, len(schema.Distances))
+	for i, name := range schema.Distances {
+		if distanceCols[i], err = column(name); err != nil {
+			return nil, err
+		}
 	}
 
 	// Assemble a complete slice of all employees in the file; we will do the initial filtering later
@@ -95,113 +122,204 @@ func ReadDatabase(r io.Reader, minPerEmployer uint, minPerJobTitle uint) (*Datab
 			return nil, errors.New(fmt.Sprintf("failed to read CSV record: %s", err))
 		}
 
-		// Extract attributes from the CSV row
-		employer := record[employerCol]
-		jobTitle := record[jobTitleCol]
-		salaryStr := record[salaryCol]
-
 		// Convert identifier
 		id, err := strconv.ParseUint(record[idCol], 10, 64)
 		if err != nil {
 			return nil, errors.New(fmt.Sprintf("data error: invalid identifier \"%s\"", record[idCol]))
 		}
 
-		// Track employer counts
-		employerNum, knownEmployer := employerSet[employer]
-		if !knownEmployer {
-			employerNum = uint(len(employerSet))
-			employerSet[employer] = employerNum
-			employerEmployees = append(employerEmployees, 0)
-		}
-		employerEmployees[employerNum]++
-
-		// Track job title counts
-		jobTitleNum, knownJobTitle := jobTitleSet[jobTitle]
-		if !knownJobTitle {
-			jobTitleNum = uint(len(jobTitleSet))
-			jobTitleSet[jobTitle] = jobTitleNum
-			jobTitleEmployees = append(jobTitleEmployees, 0)
+		// Add the record to the tentative list
+		// We might initially filter out this record later
+		// The unique value references are to the full array of attributes, which will themselves be filtered
+		employee := &Employee{
+			Id:         id,
+			Attributes: make([]uint, len(schema.Attributes)),
+			Distances:  make([]float64, len(schema.Distances)),
 		}
-		jobTitleEmployees[jobTitleNum]++
 
-		// Keep track of unique years
-		year, err := strconv.ParseUint(record[yearCol], 10, 16)
-		if err != nil {
-			return nil, errors.New(fmt.Sprintf("data error: invalid calendar year \"%s\"", record[yearCol]))
+		// Clean up the distance attributes, before the record counts toward its attribute values in case it is skipped
+		rejected := false
+		for i, name := range schema.Distances {
+			amount, err := amounts.Parse(record[distanceCols[i]])
+			if err != nil {
+				line, _ := in.FieldPos(distanceCols[i])
+				if amounts.Invalid == "fail" {
+					return nil, errors.New(fmt.Sprintf("data error: invalid %s \"%s\" on line %d: %s", name, record[distanceCols[i]], line, err))
+				}
+				db.Rejections = append(db.Rejections, AmountRejection{Line: line, Column: name, Value: record[distanceCols[i]], Reason: err.Error()})
+				rejected = true
+				amount = math.NaN() // Imputed below
+			}
+			employee.Distances[i] = amount
 		}
-		yearNum, knownYear := yearSet[uint16(year)]
-		if !knownYear {
-			yearNum = uint(len(db.Years))
-			yearSet[uint16(year)] = yearNum
-			db.Years = append(db.Years, uint16(year))
+		if rejected && amounts.Invalid == "skip" {
+			db.SkippedRecords++
+			continue
 		}
 
-		// Clean up the salary attribute
-		if salaryStr[0] == '$' {
-			salaryStr = salaryStr[1:]
-		}
-		salaryStr = strings.Replace(salaryStr, ",", "", -1)
-		if salaryDecimal := strings.IndexRune(salaryStr, '.'); salaryDecimal >= 0 {
-			if salaryDecimal == 0 {
-				salaryStr = "0"
-			} else {
-				salaryStr = salaryStr[:salaryDecimal]
+		// Track attribute value counts
+		for i, attribute := range schema.Attributes {
+			value := record[attributeCols[i]]
+			if attribute.Numeric {
+				if _, err := strconv.ParseFloat(value, 64); err != nil {
+					return nil, errors.New(fmt.Sprintf("data error: invalid %s \"%s\"", attribute.Column, value))
+				}
 			}
-		}
-		salary, err := strconv.ParseUint(salaryStr, 10, 32)
-		if err != nil {
-			return nil, errors.New(fmt.Sprintf("data error: invalid salary \"%s\"", record[salaryCol]))
+			av := values[i]
+			valueNum, known := av.set[value]
+			if !known {
+				valueNum = uint(len(av.names))
+				av.set[value] = valueNum
+				av.names = append(av.names, value)
+				av.records = append(av.records, 0)
+			}
+			av.records[valueNum]++
+			employee.Attributes[i] = valueNum
 		}
 
-		// Add the record to the tentative list
-		// We might initially filter out this record later
-		// The unique value references are to the full array of attributes, which will themselves be filtered
-		employee := &Employee{
-			Id:       id,
-			Employer: employerNum,
-			JobTitle: jobTitleNum,
-			Year:     yearNum,
-			Salary:   uint32(salary),
-		}
 		unfilteredEmployees = append(unfilteredEmployees, employee)
 	}
 
-	// Initially filter the database to our subset of interest
-	// First exclude all attributes that are too small
-	employerNumPatches := make(map[uint]uint, len(employerSet))
-	for employer, employerNum := range employerSet {
-		if employerEmployees[employerNum] >= minPerEmployer {
-			employerNumPatches[employerNum] = uint(len(employerNumPatches))
-			db.Employers = append(db.Employers, employer)
+	// Impute the invalid amounts of each distance column with the median of its valid amounts
+	if len(db.Rejections) > 0 && amounts.Invalid == "impute" {
+		for i, name := range schema.Distances {
+			var valid []float64
+			for _, employee := range unfilteredEmployees {
+				if !math.IsNaN(employee.Distances[i]) {
+					valid = append(valid, employee.Distances[i])
+				}
+			}
+			if len(valid) == 0 {
+				return nil, errors.New(fmt.Sprintf("data error: no valid %s to impute from", name))
+			}
+			imputed := amounts.round(median(valid))
+			for _, employee := range unfilteredEmployees {
+				if math.IsNaN(employee.Distances[i]) {
+					employee.Distances[i] = imputed
+				}
+			}
 		}
 	}
-	jobTitleNumPatches := make(map[uint]uint, len(jobTitleSet))
-	for jobTitle, jobTitleNum := range jobTitleSet {
-		if jobTitleEmployees[jobTitleNum] >= minPerJobTitle {
-			jobTitleNumPatches[jobTitleNum] = uint(len(jobTitleNumPatches))
-			db.JobTitles = append(db.JobTitles, jobTitle)
+
+	// Fix the final order of the attribute values, then initially filter the database to our subset of interest by
+	// excluding all attribute values that are too small
+	valueNumPatches := make([]map[uint]uint, len(schema.Attributes))
+	for i, schemaAttribute := range schema.Attributes {
+		av := values[i]
+		names := append([]string(nil), av.names...)
+		if order == SortedOrder {
+			sort.Strings(names)
+			if schemaAttribute.Numeric {
+				// Equal numbers written differently stay in lexicographic order
+				sort.SliceStable(names, func(a, b int) bool { return parseNumber(names[a]) < parseNumber(names[b]) })
+			}
+		}
+
+		attribute := &Attribute{Name: schemaAttribute.Column, Excluded: make(map[string]uint)}
+		valueNumPatches[i] = make(map[uint]uint, len(names))
+		for _, value := range names {
+			valueNum := av.set[value]
+			if av.records[valueNum] >= schemaAttribute.MinRecords {
+				valueNumPatches[i][valueNum] = uint(len(attribute.Values))
+				attribute.Values = append(attribute.Values, value)
+				if schemaAttribute.Numeric {
+					attribute.Numbers = append(attribute.Numbers, parseNumber(value))
+				}
+			} else {
+				attribute.Excluded[value] = av.records[valueNum]
+			}
 		}
+		db.Attributes = append(db.Attributes, attribute)
 	}
 	// Patch the indices already in the records to reference the filtered attribute sets
 	for _, employee := range unfilteredEmployees {
-		newEmployerIndex, validEmployer := employerNumPatches[employee.Employer]
-		newJobTitleIndex, validJobTitle := jobTitleNumPatches[employee.JobTitle]
-		if validEmployer && validJobTitle {
-			employee.Employer = newEmployerIndex
-			employee.JobTitle = newJobTitleIndex
+		valid := true
+		for i, valueNum := range employee.Attributes {
+			newIndex, validValue := valueNumPatches[i][valueNum]
+			employee.Attributes[i] = newIndex
+			valid = valid && validValue
+		}
+		if valid {
 			db.Employees = append(db.Employees, employee)
 		}
 	}
 
+	db.IndexValues()
 	return db, nil
 }
 
-func (db *Database) Filter(validEmployers []bool, validJobTitles []bool, validYears []bool, out chan<- bool) {
+// parseNumber returns the value of a numeric attribute, which ReadDatabase has already checked
+func parseNumber(s string) float64 {
+	number, _ := strconv.ParseFloat(s, 64)
+	return number
+}
+
+// Attribute returns the index of the named attribute, or -1
+func (db *Database) Attribute(name string) int {
+	for i, attribute := range db.Attributes {
+		if attribute.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// ValueIndex returns the index of a value of an attribute, explaining why it cannot be found otherwise
+func (db *Database) ValueIndex(attribute int, value string) (int, error) {
+	a := db.Attributes[attribute]
+	if i := indexOf(a.Values, value); i >= 0 {
+		return i, nil
+	}
+	if count, excluded := a.Excluded[value]; excluded {
+		return -1, errors.New(fmt.Sprintf("%s \"%s\" has only %d records and was removed by initial filtering", a.Name, value, count))
+	}
+	return -1, errors.New(fmt.Sprintf("unknown %s \"%s\"", a.Name, value))
+}
+
+// IndexValues builds the bitsets of the records with each attribute value
+func (db *Database) IndexValues() {
+	words := (len(db.Employees) + 63) / 64
+	db.ValueMasks = make([][][]uint64, len(db.Attributes))
+	for dimension, attribute := range db.Attributes {
+		db.ValueMasks[dimension] = make([][]uint64, len(attribute.Values))
+		for value := range db.ValueMasks[dimension] {
+			db.ValueMasks[dimension][value] = make([]uint64, words)
+		}
+	}
+	for i, employee := range db.Employees {
+		bit := uint64(1) << uint(i%64)
+		for dimension, value := range employee.Attributes {
+			db.ValueMasks[dimension][value][i/64] |= bit
+		}
+	}
+}
+
+// Hash identifies the contents of the database after initial filtering, including the indexing of attribute values
+func (db *Database) Hash() string {
+	h := sha256.New()
+	writeString := func(s string) {
+		binary.Write(h, binary.LittleEndian, uint64(len(s)))
+		h.Write([]byte(s))
+	}
+	binary.Write(h, binary.LittleEndian, uint64(len(db.Attributes)))
+	for _, attribute := range db.Attributes {
+		writeString(attribute.Name)
+		binary.Write(h, binary.LittleEndian, uint64(len(attribute.Values)))
+		for _, value := range attribute.Values {
+			writeString(value)
+		}
+	}
+	binary.Write(h, binary.LittleEndian, uint64(len(db.Distances)))
+	for _, name := range db.Distances {
+		writeString(name)
+	}
+	binary.Write(h, binary.LittleEndian, uint64(len(db.Employees)))
 	for _, employee := range db.Employees {
-		valid := validEmployers[employee.Employer] &&
-			validJobTitles[employee.JobTitle] &&
-			validYears[employee.Year]
-		out <- valid
+		binary.Write(h, binary.LittleEndian, employee.Id)
+		for _, value := range employee.Attributes {
+			binary.Write(h, binary.LittleEndian, uint64(value))
+		}
+		binary.Write(h, binary.LittleEndian, employee.Distances)
 	}
-	close(out)
+	return hex.EncodeToString(h.Sum(nil))
 }
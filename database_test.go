@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInvalidAmountPolicies(t *testing.T) {
+	tests := []struct {
+		invalid  string
+		salaries []string  // Of records 1, 2, ... on lines 2, 3, ...
+		amounts  []float64 // Of the records that were kept, in order
+		skipped  int
+		lines    []int  // Of the rejected amounts
+		err      string // Part of the error when the load must fail
+	}{
+		{"fail", []string{"$100", "$200.50", "(300)"}, []float64{100, 200, -300}, 0, nil, ""},
+		{"fail", []string{"$100", "N/A", "$300"}, nil, 0, nil, "\"N/A\" on line 3"},
+		{"skip", []string{"$100", "N/A", "$300", "", "$500"}, []float64{100, 300, 500}, 2, []int{3, 5}, ""},
+		{"skip", []string{"N/A", ""}, []float64{}, 2, []int{2, 3}, ""},
+		{"impute", []string{"$100", "N/A", "$300", "$200"}, []float64{100, 200, 300, 200}, 0, []int{3}, ""},
+		{"impute", []string{"", "$100", "$301", "N/A"}, []float64{200, 100, 301, 200}, 0, []int{2, 5}, ""},
+		{"impute", []string{"N/A", ""}, nil, 0, nil, "no valid Salary Paid to impute from"},
+	}
+	for _, test := range tests {
+		var csv strings.Builder
+		fmt.Fprintln(&csv, ",Employer,Job Title,Salary Paid,Calendar Year")
+		for i, salary := range test.salaries {
+			fmt.Fprintf(&csv, "%d,A,T,\"%s\",2010\n", i+1, salary)
+		}
+		cfg := testConfig()
+		for i := range cfg.Schema.Attributes {
+			cfg.Schema.Attributes[i].MinRecords = 0
+		}
+		cfg.Amounts.Invalid = test.invalid
+		db, err := ReadDatabase(strings.NewReader(csv.String()), cfg.Schema, cfg.Amounts, AttributeOrder(cfg.AttributeOrder))
+		name := fmt.Sprintf("%s %q", test.invalid, test.salaries)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: the error is %v, expected one with %s", name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		amounts := []float64{}
+		for _, employee := range db.Employees {
+			amounts = append(amounts, employee.Distances[0])
+		}
+		if !reflect.DeepEqual(amounts, test.amounts) {
+			t.Errorf("%s: the amounts are %v, expected %v", name, amounts, test.amounts)
+		}
+		if db.SkippedRecords != test.skipped {
+			t.Errorf("%s: %d records were skipped, expected %d", name, db.SkippedRecords, test.skipped)
+		}
+		var lines []int
+		for _, rejection := range db.Rejections {
+			if rejection.Column != "Salary Paid" {
+				t.Errorf("%s: an amount of %s was rejected", name, rejection.Column)
+			}
+			lines = append(lines, rejection.Line)
+		}
+		if !reflect.DeepEqual(lines, test.lines) {
+			t.Errorf("%s: the amounts on lines %v were rejected, expected %v", name, lines, test.lines)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import "math"
+
+// Detector finds the outliers among the records of a subset given by an inclusion mask, calling outlierHandler for each
+// of them until it returns false. A detector keeps memory between calls, so each thread needs its own from NewDetector.
+type Detector interface {
+	FindOutliers(im *InclusionMask, outlierHandler OutlierHandler)
+	// Stats counts the scores and subsets that the detector could not use, over all calls
+	Stats() *DetectorStats
+}
+
+// DetectorStats counts the scores and subsets that a detector could not use
+type DetectorStats struct {
+	// Number of scores that were NaN or infinite, which are never reported as outliers
+	NonFinite uint64
+	// Number of subsets that got no scores, by reason
+	Skips [numSkipReasons]uint64
+}
+
+// finite reports whether a score is a number, and counts those that are not
+func (stats *DetectorStats) finite(score float64) bool {
+	if math.IsNaN(score) || math.IsInf(score, 0) {
+		stats.NonFinite++
+		return false
+	}
+	return true
+}
+
+// SkipReason is why the records of a subset get no scores
+type SkipReason int
+
+const (
+	SkipBelowMinPopulation SkipReason = iota // Fewer records than the minimum population size, checked by the callers
+	SkipNoNeighbors                          // A single record has no neighbors to compare to
+	SkipAtMostK                              // At most k records, and small populations are skipped
+	numSkipReasons
+)
+
+func (reason SkipReason) String() string {
+	switch reason {
+	case SkipBelowMinPopulation:
+		return "fewer records than the minimum population size"
+	case SkipNoNeighbors:
+		return "a single record"
+	case SkipAtMostK:
+		return "at most k records"
+	}
+	return "unknown"
+}
+
+// NewDetector creates the detector selected by the configuration, for use by a single thread. The LOF and kNN distance
+// detectors need lof for its neighbor index and metric; the others work without it.
+func NewDetector(cfg *Config, db *Database, lof *Lof) Detector {
+	switch cfg.Detector {
+	case "zscore", "mad":
+		return &ZScoreDetector{Db: db, Threshold: cfg.Threshold(), Robust: cfg.Detector == "mad"}
+	case "knn":
+		return &KnnDistanceDetector{Lof: lof, neighbors: make([]uint64, lof.K)}
+	case "histogram":
+		return &HistogramDetector{Db: db, Threshold: cfg.Threshold(), Bins: cfg.HistogramBins}
+	}
+	return &LofDetector{Lof: lof, Cache: lof.NewThreadCache()}
+}
+
+// DetectorNeedsNeighbors reports whether a detector uses the nearest neighbor index
+func DetectorNeedsNeighbors(detector string) bool {
+	return detector == "lof" || detector == "knn"
+}
+
+// DetectorScoreName names the scores of a detector in reports
+func DetectorScoreName(detector string) string {
+	switch detector {
+	case "zscore":
+		return "z-score"
+	case "mad":
+		return "robust z-score"
+	case "knn":
+		return "kNN distance"
+	case "histogram":
+		return "histogram score"
+	}
+	return "LOF"
+}
+
+// DefaultThreshold returns the minimum score of an outlier of a detector when none is configured, or 0 for the kNN
+// distance, whose scores are in units of the metric
+func DefaultThreshold(detector string) float64 {
+	switch detector {
+	case "zscore":
+		return 3
+	case "mad":
+		return 3.5 // The cutoff of Iglewicz and Hoaglin for robust z-scores
+	case "knn":
+		return 0
+	case "histogram":
+		return math.Log(10) // Ten times rarer than the fullest bin in a single column
+	}
+	return 1.5
+}
+
+// LofDetector finds the outliers by LOF with the cache of a thread
+type LofDetector struct {
+	Lof   *Lof
+	Cache *LofCache
+}
+
+func (d *LofDetector) FindOutliers(im *InclusionMask, outlierHandler OutlierHandler) {
+	d.Lof.FindOutliers(d.Cache, im, outlierHandler)
+}
+
+func (d *LofDetector) Stats() *DetectorStats {
+	return &d.Cache.DetectorStats
+}
+
+// ZScoreDetector scores a record by how many standard deviations its amounts lie from the mean of the subset, taking
+// the largest score over the distance columns. The robust variant takes the median and the median absolute deviation
+// (MAD) instead, scaled to the standard deviation of normal data, which the outliers themselves cannot inflate.
+type ZScoreDetector struct {
+	Db        *Database
+	Threshold float64
+	Robust    bool
+
+	stats      DetectorStats
+	amounts    []float64
+	deviations []float64
+	scores     []float64
+}
+
+// Ratio of the standard deviation to the MAD, and to the mean absolute deviation, for normal data
+const (
+	madScale            = 1.4826
+	meanDeviationsScale = 1.2533
+)
+
+func (d *ZScoreDetector) FindOutliers(im *InclusionMask, outlierHandler OutlierHandler) {
+	if im.Count <= 1 {
+		d.stats.Skips[SkipNoNeighbors]++
+		return
+	}
+	d.scores = d.scores[:0]
+	for column := range d.Db.Distances {
+		d.amounts = d.amounts[:0]
+		for i, employee := range d.Db.Employees {
+			if im.IsIncluded(uint64(i)) {
+				d.amounts = append(d.amounts, employee.Distances[column])
+			}
+		}
+		center, scale := d.spread()
+		for r, amount := range d.amounts {
+			// Without any spread, all the amounts equal the center
+			score := 0.0
+			if scale > 0 {
+				score = math.Abs(amount-center) / scale
+			}
+			if column == 0 {
+				d.scores = append(d.scores, score)
+			} else if score > d.scores[r] {
+				d.scores[r] = score
+			}
+		}
+	}
+
+	r := 0
+	for i := range d.Db.Employees {
+		if !im.IsIncluded(uint64(i)) {
+			continue
+		}
+		score := d.scores[r]
+		r++
+		if !d.stats.finite(score) {
+			continue
+		}
+		if score >= d.Threshold {
+			if !outlierHandler(d.Db.Employees[i], score) {
+				return
+			}
+		}
+	}
+}
+
+// spread returns the center and the scale of the amounts. When more than half of the amounts are equal, the MAD is
+// zero, and the mean absolute deviation from the median takes its place.
+func (d *ZScoreDetector) spread() (center float64, scale float64) {
+	n := float64(len(d.amounts))
+	if !d.Robust {
+		for _, amount := range d.amounts {
+			center += amount
+		}
+		center /= n
+		for _, amount := range d.amounts {
+			scale += (amount - center) * (amount - center)
+		}
+		return center, math.Sqrt(scale / n)
+	}
+
+	center = median(d.amounts)
+	d.deviations = d.deviations[:0]
+	var sum float64
+	for _, amount := range d.amounts {
+		deviation := math.Abs(amount - center)
+		d.deviations = append(d.deviations, deviation)
+		sum += deviation
+	}
+	if mad := median(d.deviations); mad > 0 {
+		return center, madScale * mad
+	}
+	return center, meanDeviationsScale * sum / n
+}
+
+func (d *ZScoreDetector) Stats() *DetectorStats {
+	return &d.stats
+}
+
+// KnnDistanceDetector scores a record by the distance to its k-th nearest neighbor in the subset, so that the records
+// far from any k others are outliers. Unlike LOF, the score is in units of the metric and ignores the local density.
+// With at most k records, the furthest other record takes the place of the k-th nearest, unless small populations are
+// skipped.
+type KnnDistanceDetector struct {
+	Lof *Lof // Provides the neighbor index, the metric, k, the threshold and the handling of small populations
+
+	stats     DetectorStats
+	neighbors []uint64
+}
+
+func (d *KnnDistanceDetector) FindOutliers(im *InclusionMask, outlierHandler OutlierHandler) {
+	lof := d.Lof
+	switch {
+	case im.Count <= 1:
+		d.stats.Skips[SkipNoNeighbors]++
+		return
+	case lof.SkipSmall && im.Count <= lof.K:
+		d.stats.Skips[SkipAtMostK]++
+		return
+	}
+	for i, employee := range lof.Db.Employees {
+		if !im.IsIncluded(uint64(i)) {
+			continue
+		}
+		count := lof.Neighbors.KNearest(im, uint64(i), d.neighbors)
+		score := float64(lof.Metric.Distance(employee, lof.Db.Employees[d.neighbors[count-1]]))
+		if !d.stats.finite(score) {
+			continue
+		}
+		if score >= lof.Threshold {
+			if !outlierHandler(employee, score) {
+				return
+			}
+		}
+	}
+}
+
+func (d *KnnDistanceDetector) Stats() *DetectorStats {
+	return &d.stats
+}
+
+// HistogramDetector scores a record by the rarity of its amounts in histograms of the subset, like HBOS
+// (histogram-based outlier score). Each distance column is divided into bins of equal width between its smallest and
+// largest amount in the subset, and the score is the sum over the columns of the logarithm of the ratio of the fullest
+// bin to the bin of the record. Records in the fullest bins score 0, and a record alone in its bin scores the logarithm
+// of the size of the fullest bin.
+type HistogramDetector struct {
+	Db        *Database
+	Threshold float64
+	Bins      uint
+
+	stats  DetectorStats
+	counts []uint64
+	bins   []int
+	scores []float64
+}
+
+func (d *HistogramDetector) FindOutliers(im *InclusionMask, outlierHandler OutlierHandler) {
+	if im.Count <= 1 {
+		d.stats.Skips[SkipNoNeighbors]++
+		return
+	}
+	d.scores = d.scores[:0]
+	for r := uint64(0); r < im.Count; r++ {
+		d.scores = append(d.scores, 0)
+	}
+	for column := range d.Db.Distances {
+		low, high := math.Inf(1), math.Inf(-1)
+		for i, employee := range d.Db.Employees {
+			if im.IsIncluded(uint64(i)) {
+				low = math.Min(low, employee.Distances[column])
+				high = math.Max(high, employee.Distances[column])
+			}
+		}
+		if !(high > low) {
+			continue // A single bin holds every record
+		}
+
+		if uint(cap(d.counts)) < d.Bins {
+			d.counts = make([]uint64, d.Bins)
+		}
+		d.counts = d.counts[:d.Bins]
+		for bin := range d.counts {
+			d.counts[bin] = 0
+		}
+		d.bins = d.bins[:0]
+		width := (high - low) / float64(d.Bins)
+		for i, employee := range d.Db.Employees {
+			if !im.IsIncluded(uint64(i)) {
+				continue
+			}
+			// The largest amount closes the last bin
+			bin := int((employee.Distances[column] - low) / width)
+			if bin >= int(d.Bins) {
+				bin = int(d.Bins) - 1
+			}
+			d.counts[bin]++
+			d.bins = append(d.bins, bin)
+		}
+		var fullest uint64
+		for _, count := range d.counts {
+			if count > fullest {
+				fullest = count
+			}
+		}
+		for r, bin := range d.bins {
+			d.scores[r] += math.Log(float64(fullest) / float64(d.counts[bin]))
+		}
+	}
+
+	r := 0
+	for i := range d.Db.Employees {
+		if !im.IsIncluded(uint64(i)) {
+			continue
+		}
+		score := d.scores[r]
+		r++
+		if !d.stats.finite(score) {
+			continue
+		}
+		if score >= d.Threshold {
+			if !outlierHandler(d.Db.Employees[i], score) {
+				return
+			}
+		}
+	}
+}
+
+func (d *HistogramDetector) Stats() *DetectorStats {
+	return &d.stats
+}
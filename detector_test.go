@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+// detectorNames lists every detector that can be selected by the configuration
+var detectorNames = []string{"lof", "zscore", "mad", "knn", "histogram"}
+
+// allScoresDetector creates the detector of a configuration with a threshold of 0, so that it reports every score
+func allScoresDetector(cfg *Config, db *Database, lof *Lof) Detector {
+	allLof := *lof
+	allLof.Threshold = 0
+	detector := NewDetector(cfg, db, &allLof)
+	switch d := detector.(type) {
+	case *ZScoreDetector:
+		d.Threshold = 0
+	case *HistogramDetector:
+		d.Threshold = 0
+	}
+	return detector
+}
+
+// detectorScores returns the score of every record of a subset by a detector, in order of decreasing score
+func detectorScores(detector Detector, im *InclusionMask) []Outlier {
+	var outliers []Outlier
+	detector.FindOutliers(im, func(employee *Employee, score float64) bool {
+		outliers = append(outliers, Outlier{Employee: employee, Score: score})
+		return true
+	})
+	sort.SliceStable(outliers, func(a, b int) bool { return outliers[a].Score > outliers[b].Score })
+	return outliers
+}
+
+func TestKnnDistanceMatchesOracle(t *testing.T) {
+	env := newTestEnv(t, DefaultSyntheticSpec(), testConfig())
+	cfg := *env.Cfg
+	cfg.Detector = "knn"
+	knn := allScoresDetector(&cfg, env.Db, env.Lof)
+	im := NewInclusionMask(env.Db)
+	contexts := 0
+	env.forEachContext(func(ctx *Context) {
+		// The oracle is slow, so only some of the contexts are compared
+		contexts++
+		if contexts%20 != 0 {
+			return
+		}
+		FilterContext(env.Db, im, ctx)
+		if im.Count < cfg.MinPopulationSize {
+			return
+		}
+		records := members(im, len(env.Db.Employees))
+		oracle := oracleKDistances(env.Db, env.Lof.Metric, env.Lof.K, records)
+		scores := make(map[*Employee]float64, len(records))
+		knn.FindOutliers(im, func(employee *Employee, score float64) bool {
+			scores[employee] = score
+			return true
+		})
+		for r, i := range records {
+			employee := env.Db.Employees[i]
+			if score, found := scores[employee]; !found || !oracleAgrees(score, oracle[r]) {
+				t.Fatalf("ID #%d has kNN distance %v but the oracle gives %v", employee.Id, score, oracle[r])
+			}
+		}
+	})
+}
+
+func TestDetectorsRankPlantedOutliers(t *testing.T) {
+	forEachSeed(t, func(t *testing.T, env *testEnv) {
+		for _, name := range detectorNames {
+			cfg := *env.Cfg
+			cfg.Detector = name
+			ranked := detectorScores(allScoresDetector(&cfg, env.Db, env.Lof), allRecords(env.Db))
+			ranks := make(map[uint64]int, len(ranked))
+			for rank, outlier := range ranked {
+				ranks[outlier.Employee.Id] = rank + 1
+			}
+			// Every planted outlier is among the highest scores, with room for as many natural outliers
+			for _, id := range env.Planted {
+				if rank, found := ranks[id]; !found || rank > 2*len(env.Planted) {
+					t.Errorf("planted outlier ID #%d has rank %d of %d by %s", id, rank, len(ranked), DetectorScoreName(name))
+				}
+			}
+		}
+	})
+}
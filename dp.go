@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+)
+
+// Utility scores candidate contexts for the exponential mechanism. Sensitivity is the largest change in Score that
+// adding or removing a single record in the database can cause.
+type Utility struct {
+	Name        string
+	Sensitivity float64
+	Score       func(ctx *Context, popSize uint64) float64
+}
+
+// Utilities holds the built-in utility functions by name
+var Utilities = map[string]*Utility{
+	// Larger populations hide the outlier in a bigger crowd
+	"popsize": {
+		Name:        "popsize",
+		Sensitivity: 1,
+		Score: func(ctx *Context, popSize uint64) float64 {
+			return float64(popSize)
+		},
+	},
+	// Every matching context is equally likely
+	"uniform": {
+		Name:        "uniform",
+		Sensitivity: 1,
+		Score: func(ctx *Context, popSize uint64) float64 {
+			return 0
+		},
+	},
+}
+
+// ExponentialMechanism samples one context from a stream of candidates with probability proportional to
+// exp(epsilon * utility / (2 * sensitivity)). It uses the Gumbel-max trick, so candidates never need to be stored: each
+// offered candidate receives a Gumbel-perturbed score and the running maximum is the selection.
+type ExponentialMechanism struct {
+	Epsilon float64
+	Utility *Utility
+
+	Candidates      uint64
+	Selected        *Context
+	SelectedPopSize uint64
+	SelectedScore   float64
+	BestKey         float64 // Perturbed score of the current selection
+}
+
+func NewExponentialMechanism(db *Database, epsilon float64, utility *Utility) *ExponentialMechanism {
+	return &ExponentialMechanism{
+		Epsilon:  epsilon,
+		Utility:  utility,
+		Selected: NewContext(db),
+		BestKey:  math.Inf(-1),
+	}
+}
+
+// Offer presents a candidate context to the mechanism. The context is copied if it becomes the current selection, so
+// the caller may reuse it afterwards. This function is not thread safe.
+func (em *ExponentialMechanism) Offer(ctx *Context, popSize uint64) {
+	score := em.Utility.Score(ctx, popSize)
+	key := em.Epsilon*score/(2*em.Utility.Sensitivity) + gumbelNoise()
+	em.Candidates++
+	if key > em.BestKey {
+		em.BestKey = key
+		em.Selected.Copy(ctx)
+		em.SelectedPopSize = popSize
+		em.SelectedScore = score
+	}
+}
+
+// gumbelNoise draws from the standard Gumbel distribution using the system's cryptographic randomness source
+func gumbelNoise() float64 {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	// Uniform on the open interval (0, 1) with 53 bits of precision
+	u := (float64(binary.LittleEndian.Uint64(buf[:])>>11) + 0.5) / (1 << 53)
+	return -math.Log(-math.Log(u))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOfferCopiesSelection(t *testing.T) {
+	db := salaryDatabase(t, 100, 200)
+	em := NewExponentialMechanism(db, 1, Utilities["uniform"])
+	ctx := NewContext(db)
+	ctx.Included[0][0] = true
+	em.Offer(ctx, 2)
+	// The caller reuses the context for the next candidate
+	ctx.Included[0][0] = false
+	if em.Candidates != 1 || em.SelectedPopSize != 2 {
+		t.Fatalf("the only candidate is not selected: %d candidates, population size %d", em.Candidates, em.SelectedPopSize)
+	}
+	if !em.Selected.Included[0][0] {
+		t.Errorf("the selection changes with the context that was offered")
+	}
+}
+
+func TestSelectionFrequencies(t *testing.T) {
+	const trials = 20000
+	const epsilon = 1
+	popSizes := []uint64{1, 2, 3}
+	db := salaryDatabase(t, 100, 200)
+	ctx := NewContext(db)
+	for name, utility := range Utilities {
+		// Every candidate is selected with probability proportional to exp(epsilon * utility / (2 * sensitivity))
+		weights := make([]float64, len(popSizes))
+		var total float64
+		for i, popSize := range popSizes {
+			weights[i] = math.Exp(epsilon * utility.Score(ctx, popSize) / (2 * utility.Sensitivity))
+			total += weights[i]
+		}
+		counts := make(map[uint64]int, len(popSizes))
+		for trial := 0; trial < trials; trial++ {
+			em := NewExponentialMechanism(db, epsilon, utility)
+			for _, popSize := range popSizes {
+				em.Offer(ctx, popSize)
+			}
+			counts[em.SelectedPopSize]++
+		}
+		// Within 5 standard deviations of the binomial count, so that the test fails by chance about once in a million
+		// runs
+		for i, popSize := range popSizes {
+			p := weights[i] / total
+			mean, sd := trials*p, math.Sqrt(trials*p*(1-p))
+			if got := float64(counts[popSize]); math.Abs(got-mean) > 5*sd {
+				t.Errorf("%s utility: the candidate with population size %d is selected %v times in %d, expected %.0f",
+					name, popSize, got, trials, mean)
+			}
+		}
+	}
+}
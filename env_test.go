@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testEnv is a synthetic database and the parameters of the tests that run on it
+type testEnv struct {
+	Cfg      *Config
+	Db       *Database
+	Lof      *Lof
+	Original *Context
+	Rand     *rand.Rand
+
+	Planted []uint64 // IDs of the planted outliers
+}
+
+// testConfig is the default configuration for synthetic databases, which starts from an original context of about an
+// eighth of the records, with 1024 supersets
+func testConfig() *Config {
+	cfg := DefaultConfig()
+	cfg.Schema = SyntheticSchema()
+	cfg.Original = []OriginalAttribute{
+		{Name: "Employer", Count: 4},
+		{Name: "Job Title", Count: 3},
+		{Name: "Calendar Year", Count: 3},
+	}
+	return cfg
+}
+
+// newTestEnv generates the database of spec and sets up LOF with the table index and the parameters of cfg
+func newTestEnv(tb testing.TB, spec *SyntheticSpec, cfg *Config) *testEnv {
+	tb.Helper()
+	var buf bytes.Buffer
+	planted, err := spec.Generate(&buf)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	env := newCsvTestEnv(tb, &buf, cfg)
+	env.Planted = planted
+	return env
+}
+
+// forEachSeed runs f as a subtest on the synthetic databases of a few seeds, with the default spec and configuration
+func forEachSeed(t *testing.T, f func(t *testing.T, env *testEnv)) {
+	for _, seed := range []int64{1, 2, 3} {
+		spec := DefaultSyntheticSpec()
+		spec.Seed = seed
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			f(t, newTestEnv(t, spec, testConfig()))
+		})
+	}
+}
+
+// newCsvTestEnv reads a database in the layout of the default schema and sets up LOF like newTestEnv
+func newCsvTestEnv(tb testing.TB, in io.Reader, cfg *Config) *testEnv {
+	tb.Helper()
+	db, err := ReadDatabase(in, cfg.Schema, cfg.Amounts, AttributeOrder(cfg.AttributeOrder))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := cfg.ValidateDatabase(db); err != nil {
+		tb.Fatal(err)
+	}
+	metric, err := NewMetric(cfg.Metric, db, cfg.MetricWeights)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	original, err := OriginalContext(cfg, db)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &testEnv{
+		Cfg:      cfg,
+		Db:       db,
+		Lof:      NewLof(db, NewKnn(db, metric, log.New(io.Discard, "", 0), time.Hour), metric, cfg),
+		Original: original,
+		Rand:     rand.New(rand.NewSource(1)),
+	}
+}
+
+// forEachContext calls f with every superset of the original context in scan order, then with as many random
+// supersets, so that consecutive contexts differ both a little and a lot
+func (env *testEnv) forEachContext(f func(ctx *Context)) {
+	scan := NewScan(env.Db, env.Lof, env.Cfg, env.Original, nil, nil)
+	ctx := NewContext(env.Db)
+	for index := uint64(1); index < scan.TotalContexts(); index++ {
+		scan.SetContext(ctx, index)
+		f(ctx)
+	}
+	for i := uint64(1); i < scan.TotalContexts(); i++ {
+		ctx.Copy(env.Original)
+		for _, flip := range scan.Flips {
+			ctx.Included[flip.Dimension][flip.Value] = env.Rand.Intn(2) == 1
+		}
+		f(ctx)
+	}
+}
+
+// targets returns every outlier of the original context as a target
+func (env *testEnv) targets() []*Target {
+	var targets []*Target
+	FindOutliers(env.Db, &LofDetector{Lof: env.Lof, Cache: env.Lof.NewThreadCache()}, NewInclusionMask(env.Db), env.Original, env.Cfg.MinPopulationSize,
+		func(employee *Employee, score float64) bool {
+			targets = append(targets, NewTarget(env.Db, Outlier{Employee: employee, Score: score}, env.Cfg))
+			return true
+		})
+	return targets
+}
+
+// scanMatches runs a scan of every superset of the original context for the targets, and returns it with its matching
+// contexts in order
+func (env *testEnv) scanMatches(cfg *Config, targets []*Target) (*Scan, []string) {
+	out := &collectingWriter{}
+	scan := NewScan(env.Db, env.Lof, cfg, env.Original, targets, out)
+	scan.Run(log.New(io.Discard, "", 0), 0, scan.TotalContexts(), 0, nil)
+	sort.Strings(out.matches)
+	return scan, out.matches
+}
+
+// salaryDatabase is a database of a single employer, job title and year with the given salaries, in order
+func salaryDatabase(tb testing.TB, salaries ...float64) *Database {
+	tb.Helper()
+	var csv bytes.Buffer
+	fmt.Fprintln(&csv, ",Employer,Job Title,Salary Paid,Calendar Year")
+	for i, salary := range salaries {
+		fmt.Fprintf(&csv, "%d,A,T,$%.2f,2010\n", i+1, salary)
+	}
+	// Every value is kept, however few records it has
+	cfg := testConfig()
+	for i := range cfg.Schema.Attributes {
+		cfg.Schema.Attributes[i].MinRecords = 0
+	}
+	db, err := ReadDatabase(&csv, cfg.Schema, cfg.Amounts, AttributeOrder(cfg.AttributeOrder))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+// salaryLof sets up LOF over a database from salaryDatabase with the absolute metric, reporting every score
+func salaryLof(db *Database, neighbors NeighborIndex, k uint64, ties string, smallPopulations string) *Lof {
+	cfg := testConfig()
+	cfg.K = k
+	cfg.Ties = ties
+	cfg.SmallPopulations = smallPopulations
+	lof := NewLof(db, neighbors, &AbsoluteMetric{}, cfg)
+	lof.Threshold = 0
+	return lof
+}
+
+// allRecords is an inclusion mask of every record of a database
+func allRecords(db *Database) *InclusionMask {
+	return firstRecords(db, len(db.Employees))
+}
+
+// firstRecords is an inclusion mask of the first n records of a database
+func firstRecords(db *Database, n int) *InclusionMask {
+	im := NewInclusionMask(db)
+	for i := 0; i < n; i++ {
+		im.Mask[i/64] |= 1 << (uint(i) % 64)
+	}
+	im.Count = uint64(n)
+	return im
+}
+
+// collectingWriter keeps a description of every matching context
+type collectingWriter struct {
+	matches []string
+	indices []uint64
+}
+
+func (cw *collectingWriter) WriteConfig(cfg *Config)           {}
+func (cw *collectingWriter) WriteAttributes(db *Database)      {}
+func (cw *collectingWriter) WriteOriginalContext(ctx *Context) {}
+func (cw *collectingWriter) WriteTargets(targets []*Target)    {}
+func (cw *collectingWriter) WriteEstimate(est *Estimate)       {}
+func (cw *collectingWriter) WriteSelections(targets []*Target) {}
+
+func (cw *collectingWriter) WriteMatch(match *MatchingContext, targets []*Target) {
+	var buf bytes.Buffer
+	match.Context.WriteTo(&buf)
+	for _, outlier := range match.OutlierList {
+		fmt.Fprintf(&buf, "%d %v\n", outlier.Employee.Id, outlier.Score)
+	}
+	cw.matches = append(cw.matches, buf.String())
+	cw.indices = append(cw.indices, match.Index)
+}
@@ -2,71 +2,67 @@ package main
 
 import (
 	"log"
+	"math"
 	"runtime"
 	"sort"
 	"time"
 )
 
+// NeighborIndex finds nearest neighbors within the subset of records selected by an inclusion mask. Neighbors at equal
+// distances are ordered by record index, so that neighborhoods are deterministic.
+type NeighborIndex interface {
+	KNearest(im *InclusionMask, i uint64, out []uint64) uint64
+	// Distance is the distance by which the index orders the neighbors of i
+	Distance(i uint64, j uint64) Distance
+	// MinDistance is the smallest non-zero distance between two records, or 0 when all records coincide
+	MinDistance() Distance
+}
+
+// Knn is a NeighborIndex that stores the full sorted list of neighbors of every record. It works with any metric, but
+// needs O(n^2) memory.
 type Knn struct {
 	Db               *Database
+	Metric           Metric
 	NearestNeighbors [][]uint64 // Sorted lists of nearest neighbor indices in Db, computed once
 }
 
-// Inclusion mask is independently stored so it can be used per-thread
+// Inclusion mask is independently stored so it can be used per-thread. Record i is bit i % 64 of word i / 64.
 type InclusionMask struct {
-	Mask  []uint8
+	Mask  []uint64
 	Count uint64
 }
 
 func NewInclusionMask(db *Database) *InclusionMask {
 	return &InclusionMask{
-		Mask:  make([]uint8, (len(db.Employees)+7)/8),
+		Mask:  make([]uint64, (len(db.Employees)+63)/64),
 		Count: 0,
 	}
 }
 
-func (im *InclusionMask) Fill(inclusion <-chan bool) {
-	var b, bit uint
-	for {
-		include, more := <-inclusion
-		if !more {
-			break
-		}
-
-		if bit == 0 {
-			im.Mask[b] = 0
-		}
-
-		if include {
-			im.Mask[b] |= (1 << (7 - bit))
-			im.Count++
-		}
-		bit++
-		if bit > 7 {
-			bit = 0
-			b++
-		}
-	}
-}
-
 func (im *InclusionMask) IsIncluded(i uint64) bool {
-	b := i / 8
-	bit := i % 8
-	return im.Mask[b]&(1<<(7-bit)) != 0
+	return im.Mask[i/64]&(1<<(i%64)) != 0
 }
 
-func NewKnn(db *Database, lg *log.Logger, printFrequency time.Duration) *Knn {
-	knn := &Knn{Db: db}
+func NewKnn(db *Database, metric Metric, lg *log.Logger, printFrequency time.Duration) *Knn {
+	knn := &Knn{Db: db, Metric: metric}
 	knn.precomputeDistances(lg, printFrequency)
 	return knn
 }
 
-func (knn *Knn) precomputeDistances(lg *log.Logger, printFrequency time.Duration) {
+// allocateNeighbors sets up NearestNeighbors as rows of a single contiguous array, which can be read and written as a
+// whole
+func (knn *Knn) allocateNeighbors() {
 	n := uint64(len(knn.Db.Employees))
+	backing := make([]uint64, n*(n-1)) // We can't neighbor ourselves
 	knn.NearestNeighbors = make([][]uint64, n)
 	for i := range knn.NearestNeighbors {
-		knn.NearestNeighbors[i] = make([]uint64, n-1) // We can't neighbor ourselves
+		knn.NearestNeighbors[i] = backing[uint64(i)*(n-1) : uint64(i+1)*(n-1)]
 	}
+}
+
+func (knn *Knn) precomputeDistances(lg *log.Logger, printFrequency time.Duration) {
+	n := uint64(len(knn.Db.Employees))
+	knn.allocateNeighbors()
 
 	// Compute the distance array in parallel because this is an O(n^2) operation
 
@@ -91,10 +87,15 @@ func (knn *Knn) precomputeDistances(lg *log.Logger, printFrequency time.Duration
 					if i == j {
 						distances[j].distance = 0
 					} else {
-						distances[j].distance = knn.Db.Employees[i].Distance(knn.Db.Employees[j])
+						distances[j].distance = knn.Metric.Distance(knn.Db.Employees[i], knn.Db.Employees[j])
 					}
 				}
-				sort.Slice(distances, func(a, b int) bool { return distances[a].distance < distances[b].distance })
+				sort.Slice(distances, func(a, b int) bool {
+					if distances[a].distance != distances[b].distance {
+						return distances[a].distance < distances[b].distance
+					}
+					return distances[a].target < distances[b].target
+				})
 				next := 0
 				for _, neighbor := range distances {
 					if neighbor.target == i {
@@ -138,3 +139,151 @@ func (knn *Knn) KNearest(im *InclusionMask, i uint64, out []uint64) uint64 {
 	}
 	return validNeighbors
 }
+
+func (knn *Knn) Distance(i uint64, j uint64) Distance {
+	return knn.Metric.Distance(knn.Db.Employees[i], knn.Db.Employees[j])
+}
+
+// MinDistance takes the nearest neighbor of every record that is not a duplicate of it
+func (knn *Knn) MinDistance() Distance {
+	var min Distance
+	for i, neighbors := range knn.NearestNeighbors {
+		for _, j := range neighbors {
+			if distance := knn.Distance(uint64(i), j); distance > 0 {
+				if min == 0 || distance < min {
+					min = distance
+				}
+				break
+			}
+		}
+	}
+	return min
+}
+
+// SortedKnn is a NeighborIndex for metrics that place records on a line. It stores a single ordering of the records
+// by position, so it needs only O(n) memory, and finds neighbors by walking outward from a record in both directions.
+type SortedKnn struct {
+	Db     *Database
+	Metric LineMetric
+
+	Order     []uint64  // Record indices sorted by position, then by index
+	Positions []float64 // Positions[r] is the position of record Order[r]
+	Rank      []uint64  // Rank[i] is the index of record i in Order
+}
+
+func NewSortedKnn(db *Database, metric LineMetric) *SortedKnn {
+	n := len(db.Employees)
+	knn := &SortedKnn{
+		Db:        db,
+		Metric:    metric,
+		Order:     make([]uint64, n),
+		Positions: make([]float64, n),
+		Rank:      make([]uint64, n),
+	}
+	for i := range knn.Order {
+		knn.Order[i] = uint64(i)
+	}
+	sort.Slice(knn.Order, func(a, b int) bool {
+		positionA, positionB := metric.Position(db.Employees[knn.Order[a]]), metric.Position(db.Employees[knn.Order[b]])
+		if positionA != positionB {
+			return positionA < positionB
+		}
+		return knn.Order[a] < knn.Order[b]
+	})
+	for r, i := range knn.Order {
+		knn.Positions[r] = metric.Position(db.Employees[i])
+		knn.Rank[i] = uint64(r)
+	}
+	return knn
+}
+
+// KNearest has the same contract as Knn.KNearest. It takes the included records below and above i in order of distance.
+// The records at the same distance form a run of equal positions on each side, and as each run is in index order, the
+// two runs are merged by index.
+func (knn *SortedKnn) KNearest(im *InclusionMask, i uint64, out []uint64) uint64 {
+	rank := knn.Rank[i]
+	position := knn.Positions[rank]
+	n := uint64(len(knn.Order))
+
+	// The next candidates on each side; below is one past the candidate so that it cannot underflow
+	below, above := rank, rank+1
+	nextBelow := func() {
+		for below > 0 && !im.IsIncluded(knn.Order[below-1]) {
+			below--
+		}
+	}
+	nextAbove := func() {
+		for above < n && !im.IsIncluded(knn.Order[above]) {
+			above++
+		}
+	}
+	nextBelow()
+	nextAbove()
+
+	var validNeighbors uint64
+	for validNeighbors < uint64(len(out)) {
+		if below == 0 && above >= n {
+			break
+		}
+		distanceBelow, distanceAbove := math.Inf(1), math.Inf(1)
+		if below > 0 {
+			distanceBelow = position - knn.Positions[below-1]
+		}
+		if above < n {
+			distanceAbove = knn.Positions[above] - position
+		}
+		nearest := math.Min(distanceBelow, distanceAbove)
+
+		// The records at the nearest distance are ranks [low, below) and [above, high), some of them excluded
+		low, high := below, above
+		if distanceBelow == nearest {
+			for low > 0 && knn.Positions[low-1] == knn.Positions[below-1] {
+				low--
+			}
+		}
+		if distanceAbove == nearest {
+			for high < n && knn.Positions[high] == knn.Positions[above] {
+				high++
+			}
+		}
+		a, b := low, above
+		for validNeighbors < uint64(len(out)) {
+			for a < below && !im.IsIncluded(knn.Order[a]) {
+				a++
+			}
+			for b < high && !im.IsIncluded(knn.Order[b]) {
+				b++
+			}
+			if a >= below && b >= high {
+				break
+			}
+			if b >= high || (a < below && knn.Order[a] < knn.Order[b]) {
+				out[validNeighbors] = knn.Order[a]
+				a++
+			} else {
+				out[validNeighbors] = knn.Order[b]
+				b++
+			}
+			validNeighbors++
+		}
+		below, above = low, high
+		nextBelow()
+		nextAbove()
+	}
+	return validNeighbors
+}
+
+func (knn *SortedKnn) Distance(i uint64, j uint64) Distance {
+	return Distance(math.Abs(knn.Positions[knn.Rank[i]] - knn.Positions[knn.Rank[j]]))
+}
+
+// MinDistance takes the smallest gap between consecutive positions
+func (knn *SortedKnn) MinDistance() Distance {
+	var min float64
+	for r := 1; r < len(knn.Positions); r++ {
+		if gap := knn.Positions[r] - knn.Positions[r-1]; gap > 0 && (min == 0 || gap < min) {
+			min = gap
+		}
+	}
+	return Distance(min)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKNearestBreaksTiesByIndex(t *testing.T) {
+	tests := []struct {
+		salaries []float64
+		k        uint64
+		record   uint64
+		want     []uint64
+	}{
+		{[]float64{10, 20, 20, 20, 30}, 2, 0, []uint64{1, 2}},
+		{[]float64{10, 20, 20, 20, 30}, 2, 4, []uint64{1, 2}},
+		{[]float64{10, 20, 20, 20, 30}, 3, 2, []uint64{1, 3, 0}},
+		{[]float64{30, 20, 20, 20, 10}, 3, 2, []uint64{1, 3, 0}},
+		{[]float64{0, 10, 20}, 1, 1, []uint64{0}},
+		{[]float64{100, 100, 100, 100}, 2, 3, []uint64{0, 1}},
+		{[]float64{100, 100, 100, 100}, 2, 0, []uint64{1, 2}},
+	}
+	for _, test := range tests {
+		db := salaryDatabase(t, test.salaries...)
+		metric := &AbsoluteMetric{}
+		indices := map[string]NeighborIndex{
+			"table":  NewKnn(db, metric, log.New(io.Discard, "", 0), time.Hour),
+			"sorted": NewSortedKnn(db, metric),
+		}
+		for name, index := range indices {
+			out := make([]uint64, test.k)
+			count := index.KNearest(allRecords(db), test.record, out)
+			if got := out[:count]; !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s index: the %d nearest neighbors of record %d among %v are %v, expected %v",
+					name, test.k, test.record, test.salaries, got, test.want)
+			}
+		}
+	}
+}
+
+func TestNeighborIndicesAgreeOnTies(t *testing.T) {
+	// Salaries in steps of 500, so that most of them are shared by several records
+	spec := DefaultSyntheticSpec()
+	spec.Step = 500
+	env := newTestEnv(t, spec, testConfig())
+	table := env.Lof.Neighbors
+	sorted := NewSortedKnn(env.Db, &AbsoluteMetric{})
+	n := uint64(len(env.Db.Employees))
+	tableNeighbors, sortedNeighbors := make([]uint64, env.Cfg.K), make([]uint64, env.Cfg.K)
+	im := NewInclusionMask(env.Db)
+	env.forEachContext(func(ctx *Context) {
+		FilterContext(env.Db, im, ctx)
+		for i := uint64(0); i < n; i++ {
+			if !im.IsIncluded(i) {
+				continue
+			}
+			count := table.KNearest(im, i, tableNeighbors)
+			if sorted.KNearest(im, i, sortedNeighbors) != count {
+				t.Fatalf("the neighbor indices find different numbers of neighbors of ID #%d", env.Db.Employees[i].Id)
+			}
+			for r := uint64(0); r < count; r++ {
+				if tableNeighbors[r] != sortedNeighbors[r] {
+					t.Fatalf("neighbor %d of ID #%d is ID #%d in the table index but ID #%d in the sorted index",
+						r+1, env.Db.Employees[i].Id, env.Db.Employees[tableNeighbors[r]].Id, env.Db.Employees[sortedNeighbors[r]].Id)
+				}
+			}
+		}
+	})
+}
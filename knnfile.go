@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// On-disk format of a Knn neighbor table, in little endian:
+//
+//	8 bytes  magic "DPOSKNN2"
+//	32 bytes KnnHash of the database and metric the table was computed for
+//	8 bytes  number of records n
+//	n rows of n-1 record indices (8 bytes each), nearest first, and in index order at equal distances
+//
+// Version 1 tables did not break ties by index, so they are not loaded.
+var knnFileMagic = []byte("DPOSKNN2")
+var knnFileMagicV1 = []byte("DPOSKNN1")
+
+// KnnHash identifies the inputs of a neighbor table: the filtered database and the metric with its parameters
+func KnnHash(db *Database, cfg *Config) []byte {
+	h := sha256.New()
+	h.Write([]byte(db.Hash()))
+	h.Write([]byte(cfg.Metric))
+	weights, err := json.Marshal(cfg.MetricWeights)
+	if err != nil {
+		panic(err)
+	}
+	h.Write(weights)
+	return h.Sum(nil)
+}
+
+// Save writes the neighbor table to a file, replacing it atomically
+func (knn *Knn) Save(fileName string, hash []byte) error {
+	tmpName := fileName + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(f, 1<<20)
+	w.Write(knnFileMagic)
+	w.Write(hash)
+	binary.Write(w, binary.LittleEndian, uint64(len(knn.NearestNeighbors)))
+	var buf [8]byte
+	for _, neighbors := range knn.NearestNeighbors {
+		for _, neighbor := range neighbors {
+			binary.LittleEndian.PutUint64(buf[:], neighbor)
+			w.Write(buf[:])
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+// LoadKnn reads a neighbor table written by Knn.Save. It refuses tables whose hash differs from the expected one,
+// since they were computed from a different database or metric.
+func LoadKnn(fileName string, db *Database, metric Metric, hash []byte) (*Knn, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReaderSize(f, 1<<20)
+
+	header := make([]byte, len(knnFileMagic)+sha256.Size+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to read neighbor table header: %s", err))
+	}
+	if bytes.Equal(header[:len(knnFileMagic)], knnFileMagicV1) {
+		return nil, errors.New(fmt.Sprintf("neighbor table \"%s\" was saved by an older version with a different order of tied neighbors; remove it to recompute it", fileName))
+	}
+	if !bytes.Equal(header[:len(knnFileMagic)], knnFileMagic) {
+		return nil, errors.New(fmt.Sprintf("\"%s\" is not a neighbor table", fileName))
+	}
+	if !bytes.Equal(header[len(knnFileMagic):len(knnFileMagic)+sha256.Size], hash) {
+		return nil, errors.New(fmt.Sprintf("neighbor table \"%s\" was computed for a different database or metric", fileName))
+	}
+	n := binary.LittleEndian.Uint64(header[len(knnFileMagic)+sha256.Size:])
+	if n != uint64(len(db.Employees)) {
+		return nil, errors.New(fmt.Sprintf("neighbor table has %d records but the database has %d", n, len(db.Employees)))
+	}
+
+	knn := &Knn{Db: db, Metric: metric}
+	knn.allocateNeighbors()
+	var buf [8]byte
+	for _, neighbors := range knn.NearestNeighbors {
+		for j := range neighbors {
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return nil, errors.New(fmt.Sprintf("failed to read neighbor table: %s", err))
+			}
+			neighbors[j] = binary.LittleEndian.Uint64(buf[:])
+			if neighbors[j] >= n {
+				return nil, errors.New("neighbor table contains an invalid record index")
+			}
+		}
+	}
+	return knn, nil
+}
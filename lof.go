@@ -2,10 +2,23 @@ package main
 
 type Lof struct {
 	Db        *Database
-	Neighbors *Knn
+	Neighbors NeighborIndex
+	Metric    Metric
 
 	K         uint64
 	Threshold float64
+
+	// Neighborhoods include every record tied with the k-th nearest neighbor, as in the textbook definition of LOF,
+	// instead of exactly k records with ties broken by record index
+	TieInclusive bool
+
+	// Subsets of at most K records get no scores, instead of neighborhoods of all their other records
+	SkipSmall bool
+
+	// Reachability distances are at least the smallest non-zero distance between two records. Records with k or more
+	// duplicates would otherwise have a zero reachability sum and an infinite LRD, which makes scores infinite or NaN.
+	// Only reachability distances that would be zero are affected, so duplicates count as the closest distinct records.
+	MinReachability Distance
 }
 
 // Reused per-thread memory for cache efficiency
@@ -13,14 +26,25 @@ type LofCache struct {
 	Neighborhoods              [][]uint64
 	CoreDistance               []Distance
 	LocalReachabilityDensities []float64
+
+	// Scores that were NaN or infinite despite MinReachability, and subsets that got no scores
+	DetectorStats
 }
 
-func NewLof(db *Database, neighbors *Knn, k uint64, threshold float64) *Lof {
+func NewLof(db *Database, neighbors NeighborIndex, metric Metric, cfg *Config) *Lof {
 	lof := &Lof{
-		Db:        db,
-		Neighbors: neighbors,
-		K:         k,
-		Threshold: threshold,
+		Db:           db,
+		Neighbors:    neighbors,
+		Metric:       metric,
+		K:            cfg.K,
+		Threshold:    cfg.Threshold(),
+		TieInclusive: cfg.Ties == "inclusive",
+		SkipSmall:    cfg.SmallPopulations == "skip",
+	}
+	lof.MinReachability = neighbors.MinDistance()
+	if lof.MinReachability == 0 {
+		// All records coincide, so any floor gives every record the same LRD
+		lof.MinReachability = 1
 	}
 	return lof
 }
@@ -39,24 +63,87 @@ func (lof *Lof) NewThreadCache() *LofCache {
 
 type OutlierHandler func(*Employee, float64) bool
 
+// Outlier is a record together with its LOF score in some context
+type Outlier struct {
+	Employee *Employee
+	Score    float64
+}
+
 // FindOutliers calls outlierHandler for each detected outlier in a subset defined by an inclusion mask. When
 // outlierHandler returns false, the procedure immediately returns. When cache is local to the calling thread, this
-// function is thread safe.
+// function is thread safe. Subsets of a single record, or of at most k records with SkipSmall, are counted in
+// cache.Skips instead; with fewer than k+1 records, the neighborhoods are all the other records.
 func (lof *Lof) FindOutliers(cache *LofCache, im *InclusionMask, outlierHandler OutlierHandler) {
+	if lof.skip(cache, im) {
+		return
+	}
 	lof.computeCoreDistances(cache, im)
 	lof.computeLrds(cache, im)
 	lof.computeLofs(cache, im, outlierHandler)
 }
 
+// skip reports whether a subset gets no scores, and counts the reason
+func (lof *Lof) skip(cache *LofCache, im *InclusionMask) bool {
+	switch {
+	case im.Count <= 1:
+		cache.Skips[SkipNoNeighbors]++
+	case lof.SkipSmall && im.Count <= lof.K:
+		cache.Skips[SkipAtMostK]++
+	default:
+		return false
+	}
+	return true
+}
+
 func (lof *Lof) computeCoreDistances(cache *LofCache, im *InclusionMask) {
 	for i := range cache.CoreDistance {
 		if !im.IsIncluded(uint64(i)) {
 			continue
 		}
-		numNeighbors := lof.Neighbors.KNearest(im, uint64(i), cache.Neighborhoods[i])
-		cache.Neighborhoods[i] = cache.Neighborhoods[i][:numNeighbors]
-		furthest := cache.Neighborhoods[i][numNeighbors-1]
-		cache.CoreDistance[i] = lof.Db.Employees[i].Distance(lof.Db.Employees[furthest])
+		lof.computeCoreDistance(cache, im, i)
+	}
+}
+
+func (lof *Lof) computeCoreDistance(cache *LofCache, im *InclusionMask, i int) {
+	// The neighborhood of the previous subset may be shorter than k or hold ties beyond k, so the buffer is taken at
+	// its full capacity again
+	neighborhood := cache.Neighborhoods[i][:cap(cache.Neighborhoods[i])]
+	if uint64(len(neighborhood)) < lof.K {
+		neighborhood = make([]uint64, lof.K)
+	}
+	numNeighbors := lof.Neighbors.KNearest(im, uint64(i), neighborhood[:lof.K])
+	cache.Neighborhoods[i] = neighborhood[:numNeighbors]
+	if numNeighbors == 0 {
+		// Only possible in a subset of one record, which skip rules out
+		cache.CoreDistance[i] = 0
+		return
+	}
+	if lof.TieInclusive && numNeighbors == lof.K {
+		cache.Neighborhoods[i] = lof.withTies(im, uint64(i), cache.Neighborhoods[i])
+	}
+	furthest := cache.Neighborhoods[i][len(cache.Neighborhoods[i])-1]
+	cache.CoreDistance[i] = lof.Metric.Distance(lof.Db.Employees[i], lof.Db.Employees[furthest])
+}
+
+// withTies extends the k nearest neighbors of i with the records tied with the k-th. The neighbors are found again with
+// room for more records until one of them is further away, and the buffer grows as needed.
+func (lof *Lof) withTies(im *InclusionMask, i uint64, neighborhood []uint64) []uint64 {
+	kDistance := lof.Neighbors.Distance(i, neighborhood[lof.K-1])
+	size := lof.K + 1
+	for {
+		if uint64(cap(neighborhood)) < size {
+			neighborhood = make([]uint64, size)
+		}
+		neighborhood = neighborhood[:size]
+		count := lof.Neighbors.KNearest(im, i, neighborhood)
+		tied := lof.K
+		for tied < count && lof.Neighbors.Distance(i, neighborhood[tied]) <= kDistance {
+			tied++
+		}
+		if tied < count || count < size {
+			return neighborhood[:tied]
+		}
+		size *= 2
 	}
 }
 
@@ -65,17 +152,23 @@ func (lof *Lof) computeLrds(cache *LofCache, im *InclusionMask) {
 		if !im.IsIncluded(uint64(i)) {
 			continue
 		}
+		cache.LocalReachabilityDensities[i] = lof.lrd(cache, i)
+	}
+}
 
-		var sum float64
-		for _, j := range cache.Neighborhoods[i] {
-			reachabilityDistance := lof.Db.Employees[j].Distance(lof.Db.Employees[i])
-			if reachabilityDistance < cache.CoreDistance[j] {
-				reachabilityDistance = cache.CoreDistance[j]
-			}
-			sum += float64(reachabilityDistance)
+func (lof *Lof) lrd(cache *LofCache, i int) float64 {
+	var sum float64
+	for _, j := range cache.Neighborhoods[i] {
+		reachabilityDistance := lof.Metric.Distance(lof.Db.Employees[j], lof.Db.Employees[i])
+		if reachabilityDistance < cache.CoreDistance[j] {
+			reachabilityDistance = cache.CoreDistance[j]
+		}
+		if reachabilityDistance < lof.MinReachability {
+			reachabilityDistance = lof.MinReachability
 		}
-		cache.LocalReachabilityDensities[i] = float64(len(cache.Neighborhoods[i])) / sum
+		sum += float64(reachabilityDistance)
 	}
+	return float64(len(cache.Neighborhoods[i])) / sum
 }
 
 func (lof *Lof) computeLofs(cache *LofCache, im *InclusionMask, outlierHandler OutlierHandler) {
@@ -84,12 +177,10 @@ func (lof *Lof) computeLofs(cache *LofCache, im *InclusionMask, outlierHandler O
 			continue
 		}
 
-		var sum float64
-		for _, j := range cache.Neighborhoods[i] {
-			sum += cache.LocalReachabilityDensities[j]
+		score := lof.score(cache, i)
+		if !cache.finite(score) {
+			continue
 		}
-		score := sum / (float64(len(cache.Neighborhoods[i])) * cache.LocalReachabilityDensities[i])
-
 		if score >= lof.Threshold {
 			if !outlierHandler(lof.Db.Employees[i], score) {
 				return
@@ -97,3 +188,11 @@ func (lof *Lof) computeLofs(cache *LofCache, im *InclusionMask, outlierHandler O
 		}
 	}
 }
+
+func (lof *Lof) score(cache *LofCache, i int) float64 {
+	var sum float64
+	for _, j := range cache.Neighborhoods[i] {
+		sum += cache.LocalReachabilityDensities[j]
+	}
+	return sum / (float64(len(cache.Neighborhoods[i])) * cache.LocalReachabilityDensities[i])
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func BenchmarkFindOutliers(b *testing.B) {
+	// The supersets of the original context in scan order, as a worker of the scan would score them
+	env := newTestEnv(b, DefaultSyntheticSpec(), testConfig())
+	scan := NewScan(env.Db, env.Lof, env.Cfg, env.Original, nil, nil)
+	ctx := NewContext(env.Db)
+	im := NewInclusionMask(env.Db)
+	cache := env.Lof.NewThreadCache()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		scan.SetContext(ctx, uint64(n)%scan.TotalContexts())
+		FilterContext(env.Db, im, ctx)
+		env.Lof.FindOutliers(cache, im, func(employee *Employee, score float64) bool { return true })
+	}
+}
+
+// salaryLofs returns a Lof for each neighbor index and way of handling ties over a database from salaryDatabase
+func salaryLofs(db *Database, k uint64, smallPopulations string) map[string]*Lof {
+	metric := &AbsoluteMetric{}
+	table := NewKnn(db, metric, log.New(io.Discard, "", 0), time.Hour)
+	sorted := NewSortedKnn(db, metric)
+	lofs := make(map[string]*Lof)
+	for _, ties := range []string{"index", "inclusive"} {
+		lofs["table index with "+ties+" ties"] = salaryLof(db, table, k, ties, smallPopulations)
+		lofs["sorted index with "+ties+" ties"] = salaryLof(db, sorted, k, ties, smallPopulations)
+	}
+	return lofs
+}
+
+// Salaries with ties at various distances and among neighbors
+var tiedSalaries = [][]float64{
+	{10, 20, 20, 20, 30},
+	{10, 20, 30, 40, 50, 60, 70, 80},
+	{100, 110, 110, 120, 120, 120, 130, 200},
+	{1000, 1500, 1500, 2000, 2000, 2500, 2500, 3000, 9000},
+}
+
+func TestTiedScoresMatchOracle(t *testing.T) {
+	for _, salaries := range tiedSalaries {
+		db := salaryDatabase(t, salaries...)
+		minReachability := oracleMinReachability(db, &AbsoluteMetric{})
+		for _, k := range []uint64{1, 2, 3} {
+			for name, lof := range salaryLofs(db, k, "adapt") {
+				if _, err := compareToOracle(db, lof, lof.NewThreadCache(), allRecords(db), minReachability); err != nil {
+					t.Errorf("%v with k %d and the %s: %s", salaries, k, name, err)
+				}
+			}
+		}
+	}
+}
+
+func TestTieInclusiveNeighborhoods(t *testing.T) {
+	tests := []struct {
+		salaries  []float64
+		k         uint64
+		record    int
+		inclusive bool
+		want      []uint64
+	}{
+		{[]float64{10, 20, 20, 20, 30}, 2, 0, false, []uint64{1, 2}},
+		{[]float64{10, 20, 20, 20, 30}, 2, 0, true, []uint64{1, 2, 3}},
+		{[]float64{10, 20, 20, 20, 30}, 2, 2, true, []uint64{1, 3}},
+		{[]float64{10, 20, 20, 20, 30}, 3, 2, true, []uint64{0, 1, 3, 4}},
+		{[]float64{0, 10, 20}, 1, 1, false, []uint64{0}},
+		{[]float64{0, 10, 20}, 1, 1, true, []uint64{0, 2}},
+	}
+	for _, test := range tests {
+		db := salaryDatabase(t, test.salaries...)
+		ties := "index"
+		if test.inclusive {
+			ties = "inclusive"
+		}
+		for name, lof := range salaryLofs(db, test.k, "adapt") {
+			if lof.TieInclusive != test.inclusive {
+				continue
+			}
+			cache := lof.NewThreadCache()
+			lof.FindOutliers(cache, allRecords(db), func(employee *Employee, score float64) bool { return true })
+			got := append([]uint64{}, cache.Neighborhoods[test.record]...)
+			sort.Slice(got, func(a, b int) bool { return got[a] < got[b] })
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s: with k %d and %s ties, the neighborhood of record %d among %v is %v, expected %v",
+					name, test.k, ties, test.record, test.salaries, got, test.want)
+			}
+		}
+	}
+}
+
+func TestDuplicatedSalaries(t *testing.T) {
+	tests := []struct {
+		salaries []float64
+		k        uint64
+	}{
+		{[]float64{100, 100, 200}, 2},
+		{[]float64{100, 100, 100, 200}, 2},
+		{[]float64{100, 100, 100, 200, 300}, 3},
+		{[]float64{100, 100, 100, 100}, 2},
+		{[]float64{50, 100, 100, 100, 100, 100, 400}, 2},
+		{[]float64{50, 100, 100, 100, 400, 400, 400, 900}, 3},
+	}
+	for _, test := range tests {
+		db := salaryDatabase(t, test.salaries...)
+		minReachability := oracleMinReachability(db, &AbsoluteMetric{})
+		for name, lof := range salaryLofs(db, test.k, "adapt") {
+			if float64(lof.MinReachability) != minReachability {
+				t.Errorf("%v with the %s: reachability distances are floored at %v instead of %v",
+					test.salaries, name, lof.MinReachability, minReachability)
+			}
+			// The oracle comparison requires finite scores
+			cache := lof.NewThreadCache()
+			if _, err := compareToOracle(db, lof, cache, allRecords(db), minReachability); err != nil {
+				t.Errorf("%v with k %d and the %s: %s", test.salaries, test.k, name, err)
+				continue
+			}
+
+			// A record with k duplicates, whose neighbors all have k duplicates too, is as dense as its neighbors
+			for i := range db.Employees {
+				if cache.CoreDistance[i] != 0 {
+					continue
+				}
+				allDuplicated := true
+				for _, j := range cache.Neighborhoods[i] {
+					allDuplicated = allDuplicated && cache.CoreDistance[j] == 0
+				}
+				if score := lof.score(cache, i); allDuplicated && score != 1 {
+					t.Errorf("%v with k %d and the %s: record %d has LOF %v among duplicates", test.salaries, test.k, name, i, score)
+				}
+			}
+		}
+	}
+}
+
+func TestSmallPopulations(t *testing.T) {
+	const k = 3
+	salaries := []float64{100, 130, 110, 180, 120, 125, 300, 90}
+	tests := []struct {
+		population       int
+		smallPopulations string
+		skip             SkipReason // numSkipReasons when the population is scored
+	}{
+		{1, "adapt", SkipNoNeighbors},
+		{1, "skip", SkipNoNeighbors},
+		{2, "adapt", numSkipReasons},
+		{2, "skip", SkipAtMostK},
+		{k, "adapt", numSkipReasons},
+		{k, "skip", SkipAtMostK},
+		{k + 1, "adapt", numSkipReasons},
+		{k + 1, "skip", numSkipReasons},
+	}
+	db := salaryDatabase(t, salaries...)
+	minReachability := oracleMinReachability(db, &AbsoluteMetric{})
+	for _, smallPopulations := range []string{"adapt", "skip"} {
+		for name, lof := range salaryLofs(db, k, smallPopulations) {
+			// A single cache goes through every population in turn, so that its neighborhoods are reused after shorter
+			// ones
+			cache := lof.NewThreadCache()
+			for _, test := range append(tests, tests...) {
+				if test.smallPopulations != smallPopulations {
+					continue
+				}
+				im := firstRecords(db, test.population)
+				skips := cache.Skips
+				if _, err := compareToOracle(db, lof, cache, im, minReachability); err != nil {
+					t.Errorf("%d records with %s small populations and the %s: %s", test.population, smallPopulations, name, err)
+				}
+				for reason := range skips {
+					want := uint64(0)
+					if SkipReason(reason) == test.skip {
+						want = 1
+					}
+					if got := cache.Skips[reason] - skips[reason]; got != want {
+						t.Errorf("%d records with %s small populations and the %s: skipped %d times with %s, expected %d",
+							test.population, smallPopulations, name, got, SkipReason(reason), want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestLofMatchesOracle(t *testing.T) {
+	forEachSeed(t, func(t *testing.T, env *testEnv) {
+		lof := *env.Lof
+		lof.Threshold = 0
+
+		minReachability := oracleMinReachability(env.Db, lof.Metric)
+		im := NewInclusionMask(env.Db)
+		cache := lof.NewThreadCache()
+		contexts := 0
+		env.forEachContext(func(ctx *Context) {
+			// The oracle is slow, so only some of the contexts are compared
+			contexts++
+			if contexts%20 != 0 {
+				return
+			}
+			FilterContext(env.Db, im, ctx)
+			if im.Count < env.Cfg.MinPopulationSize {
+				return
+			}
+			if _, err := compareToOracle(env.Db, &lof, cache, im, minReachability); err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+}
+
+func TestPlantedOutliersFound(t *testing.T) {
+	forEachSeed(t, func(t *testing.T, env *testEnv) {
+		if records := DefaultSyntheticSpec().Records; len(env.Db.Employees) != records {
+			t.Fatalf("the synthetic schema keeps %d of %d records", len(env.Db.Employees), records)
+		}
+		scores := make(map[uint64]float64)
+		env.Lof.FindOutliers(env.Lof.NewThreadCache(), allRecords(env.Db), func(employee *Employee, score float64) bool {
+			scores[employee.Id] = score
+			return true
+		})
+		for _, id := range env.Planted {
+			if _, found := scores[id]; !found {
+				t.Errorf("planted outlier ID #%d is not an outlier among all records", id)
+			}
+		}
+	})
+}
@@ -1,330 +1,327 @@
 package main
 
 import (
-	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"math"
 	"os"
 	"runtime"
+	"sort"
 	"time"
 )
 
-type Context struct {
-	EmployersIncluded []bool
-	JobTitlesIncluded []bool
-	YearsIncluded     []bool
-}
-
-func NewContext(db *Database) *Context {
-	return &Context{
-		EmployersIncluded: make([]bool, len(db.Employers)),
-		JobTitlesIncluded: make([]bool, len(db.JobTitles)),
-		YearsIncluded:     make([]bool, len(db.Years)),
-	}
-}
-
-func (ctx *Context) Copy(other *Context) {
-	copy(ctx.EmployersIncluded, other.EmployersIncluded)
-	copy(ctx.JobTitlesIncluded, other.JobTitlesIncluded)
-	copy(ctx.YearsIncluded, other.YearsIncluded)
-}
+func main() {
+	lg := log.New(os.Stderr, "", log.Ldate|log.Ltime)
 
-func (ctx *Context) WriteTo(w io.Writer) {
-	dumpIncluded := func(arr []bool) {
-		fmt.Fprintf(w, "[")
-		first := true
-		for i, included := range arr {
-			if !included {
-				continue
-			}
-			if first {
-				first = false
-			} else {
-				fmt.Fprint(w, ", ")
-			}
-			fmt.Fprintf(w, "%d", i)
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		if err := RunMerge(os.Args[1:], lg); err != nil {
+			lg.Fatalf("Failed to merge shard outputs: %s\n", err)
 		}
-		fmt.Fprintln(w, "]")
+		return
 	}
 
-	fmt.Fprintln(w, "{")
-	fmt.Fprintf(w, "  Employers: ")
-	dumpIncluded(ctx.EmployersIncluded)
-	fmt.Fprintf(w, "  Job Titles: ")
-	dumpIncluded(ctx.JobTitlesIncluded)
-	fmt.Fprintf(w, "  Calendar Years: ")
-	dumpIncluded(ctx.YearsIncluded)
-	fmt.Fprintln(w, "}")
-}
-
-func main() {
-	if len(os.Args) != 3 {
-		fmt.Fprintf(os.Stderr, "Usage: %s INFILE OUTFILE\n", os.Args[0])
-		os.Exit(1)
+	cfg, err := ParseConfig(os.Args)
+	if err != nil {
+		lg.Fatalf("Invalid configuration: %s\n", err)
 	}
 
-	lg := log.New(os.Stderr, "", log.Ldate|log.Ltime)
-
-	inFile, err := os.Open(os.Args[1])
+	inFile, err := os.Open(cfg.InFile)
 	if err != nil {
 		lg.Fatalf("Failed to open input file for reading: %s\n", err)
 	}
 	defer inFile.Close()
 
-	outRaw, err := os.Create(fmt.Sprintf("%s.gz", os.Args[2]))
-	if err != nil {
-		lg.Fatalf("Failed to open output file for writing: %s\n", err)
-		os.Exit(1)
+	// A resumed scan picks up from its checkpoint
+	var checkpoint *Checkpoint
+	if cfg.Resume {
+		checkpoint, err = LoadCheckpoint(CheckpointFileName(cfg))
+		if err != nil {
+			lg.Fatalf("Failed to load checkpoint: %s\n", err)
+		}
 	}
-	defer outRaw.Close()
-	outFile := gzip.NewWriter(outRaw)
-	defer outFile.Close()
 
 	lg.Printf("Using parallelism over %d threads\n", runtime.NumCPU())
 
-	lg.Printf("Reading records from input file \"%s\"\n", os.Args[1])
+	lg.Printf("Reading records from input file \"%s\"\n", cfg.InFile)
 
-	const MinPerEmployer = 3000
-	const MinPerJobTitle = 3000
-
-	db, err := ReadDatabase(inFile, MinPerEmployer, MinPerJobTitle)
+	db, err := ReadDatabase(inFile, cfg.Schema, cfg.Amounts, AttributeOrder(cfg.AttributeOrder))
 	if err != nil {
 		lg.Fatalf("Failed to read employee database: %s\n", err)
 		os.Exit(1)
 	}
+	LogRejections(lg, db, cfg.Amounts)
 
 	lg.Printf("Database contains %d records (after initial filtering)\n", len(db.Employees))
 
-	// The output file needs to have the list of attributes because they are scrambled with each load
-	fmt.Fprintln(outFile, "Employers:")
-	for i, employer := range db.Employers {
-		fmt.Fprintf(outFile, "  %d: %s\n", i, employer)
-	}
-	fmt.Fprintln(outFile, "\nJob Titles:")
-	for i, jobTitle := range db.JobTitles {
-		fmt.Fprintf(outFile, "  %d: %s\n", i, jobTitle)
-	}
-	fmt.Fprintln(outFile, "\nCalendar Years:")
-	for i, year := range db.Years {
-		fmt.Fprintf(outFile, "  %d: %d\n", i, year)
+	if err := cfg.ValidateDatabase(db); err != nil {
+		lg.Fatalf("Invalid configuration for this database: %s\n", err)
 	}
-	fmt.Fprintln(outFile)
 
-	// Precompute nearest neighbors
-	const K = 20
-	const OutlierThreshold = 1.5
-
-	const PrintFrequency = time.Second * 30
-
-	lg.Println("Precomputing nearest neighbors for all records")
-	neighbors := NewKnn(db, lg, PrintFrequency)
-	lg.Println("Completed nearest neighbor computation")
+	metric, err := NewMetric(cfg.Metric, db, cfg.MetricWeights)
+	if err != nil {
+		lg.Fatalf("Failed to create distance metric: %s\n", err)
+	}
 
-	lof := NewLof(db, neighbors, K, OutlierThreshold)
+	// Precompute nearest neighbors, unless the detector only looks at the amounts
+	var lof *Lof
+	if DetectorNeedsNeighbors(cfg.Detector) {
+		neighbors, err := NewNeighborIndex(cfg, db, metric, lg)
+		if err != nil {
+			lg.Fatalf("Failed to create nearest neighbor index: %s\n", err)
+		}
+		lof = NewLof(db, neighbors, metric, cfg)
+	}
+	scoreName := DetectorScoreName(cfg.Detector)
+	lg.Printf("Detecting outliers by %s\n", scoreName)
 
 	CleanRam(lg)
 
 	// Create an original context
-	const OrigCtxEmployersCount = 6
-	const OrigCtxJobTitlesCount = 5
-	const OrigCtxYearsCount = 5
-	ctx := NewContext(db)
-	for i := 0; i < OrigCtxEmployersCount; i++ {
-		ctx.EmployersIncluded[i] = true
-	}
-	for i := 0; i < OrigCtxJobTitlesCount; i++ {
-		ctx.JobTitlesIncluded[i] = true
-	}
-	for i := 0; i < OrigCtxYearsCount; i++ {
-		ctx.YearsIncluded[i] = true
+	ctx, err := OriginalContext(cfg, db)
+	if err != nil {
+		lg.Fatalf("Failed to form original context: %s\n", err)
 	}
-	lg.Printf("Formed original context with %d employers, %d job titles, and %d years\n", OrigCtxEmployersCount, OrigCtxJobTitlesCount, OrigCtxYearsCount)
-	fmt.Fprintln(outFile, "Original context:")
-	ctx.WriteTo(outFile)
-	fmt.Fprintln(outFile)
+	lg.Printf("Formed original context with %s\n", ctx.Summary())
 
-	// Find the first outlier in this original context
+	// Find the outliers in this original context and choose the one to explain
 	origIm := NewInclusionMask(db)
-	origCache := lof.NewThreadCache()
-	var origOutlier *Employee
-	var origScore float64
-	FindOutliers(db, lof, origCache, origIm, ctx, func(outlier *Employee, score float64) bool {
-		origOutlier = outlier
-		origScore = score
-		return false
+	origDetector := NewDetector(cfg, db, lof)
+	var origOutliers []Outlier
+	FindOutliers(db, origDetector, origIm, ctx, cfg.MinPopulationSize, func(outlier *Employee, score float64) bool {
+		origOutliers = append(origOutliers, Outlier{Employee: outlier, Score: score})
+		return true
 	})
-	if origOutlier == nil {
+	origStats := origDetector.Stats()
+	if origStats.NonFinite > 0 {
+		lg.Printf("Warning: %d scores of the original context are NaN or infinite and were ignored\n", origStats.NonFinite)
+	}
+	for reason, count := range origStats.Skips {
+		if count > 0 {
+			lg.Fatalf("Error: original context gets no scores with %s!\n", SkipReason(reason))
+		}
+	}
+	if len(origOutliers) == 0 {
 		lg.Fatalln("Error: original context contains no outliers!")
 	}
-	lg.Printf("First outlier in original context is ID #%d with LOF %f\n", origOutlier.Id, origScore)
-	fmt.Fprintf(outFile, "Original outlier with LOF %f: ID #%d, employer %d, job title %d, calendar year %d\n\n", origScore,
-		origOutlier.Id, origOutlier.Employer, origOutlier.JobTitle, origOutlier.Year)
+	lg.Printf("Original context contains %d outliers\n", len(origOutliers))
 
-	// Now try all other possible superset contexts to see if this record is still an outlier
-	// We do this in parallel for performance
-
-	workerCount := runtime.NumCPU()
+	// In batch mode every outlier is explained by the same scan
+	var targets []*Target
+	if cfg.AllOutliers {
+		for _, outlier := range origOutliers {
+			targets = append(targets, NewTarget(db, outlier, cfg))
+		}
+	} else {
+		outlier, err := SelectTarget(db, origIm, origOutliers, cfg.TargetId, cfg.TargetRank)
+		if err != nil {
+			lg.Fatalf("Failed to select the outlier to explain: %s\n", err)
+		}
+		targets = append(targets, NewTarget(db, outlier, cfg))
+	}
+	for _, target := range targets {
+		lg.Printf("Explaining ID #%d with %s %f\n", target.Employee.Id, scoreName, target.Score)
 
-	var flippableVariables float64
-	flippableVariables += float64(len(db.Employers) - OrigCtxEmployersCount)
-	flippableVariables += float64(len(db.JobTitles) - OrigCtxJobTitlesCount)
-	flippableVariables += float64(len(db.Years) - OrigCtxYearsCount)
-	totalContexts := uint64(math.Exp2(flippableVariables))
-	lg.Printf("Scanning %d contexts with %d threads\n", totalContexts, workerCount)
-	scanStartTime := time.Now()
+		// The original context is itself a candidate for release (by the first shard only, so merging counts it once)
+		if cfg.Shard == 0 {
+			target.Mechanism.Offer(ctx, origIm.Count)
+		}
+	}
 
-	type matchingOutlier struct {
-		employee *Employee
-		score    float64
-	}
-	type matchingContext struct {
-		context       *Context
-		popSize       uint64
-		outlierList   []matchingOutlier
-		printedNotice chan struct{}
-	}
-
-	workChan := make(chan *Context)
-	ctxReuseChan := make(chan *Context, workerCount)
-	matchingContextChan := make(chan *matchingContext)
-	finishedChan := make(chan struct{})
-	for worker := 0; worker < workerCount; worker++ {
-		go func() {
-			defer func() { finishedChan <- struct{}{} }()
-
-			// Thread local storage that gets reused between contexts under analysis
-			cache := lof.NewThreadCache()
-			im := NewInclusionMask(db)
-			match := &matchingContext{
-				printedNotice: make(chan struct{}),
-			}
+	// Open the output, continuing after the checkpointed part when resuming
+	var outRaw *os.File
+	if checkpoint != nil {
+		outRaw, err = os.OpenFile(fmt.Sprintf("%s.gz", cfg.OutFile), os.O_RDWR, 0)
+		if err == nil {
+			err = outRaw.Truncate(checkpoint.OutputOffset)
+		}
+		if err == nil {
+			_, err = outRaw.Seek(checkpoint.OutputOffset, io.SeekStart)
+		}
+	} else {
+		outRaw, err = os.Create(fmt.Sprintf("%s.gz", cfg.OutFile))
+	}
+	if err != nil {
+		lg.Fatalf("Failed to open output file for writing: %s\n", err)
+	}
+	outFile := NewGzipMembers(outRaw)
+	out, err := NewResultWriter(cfg.Format, scoreName, outFile)
+	if err != nil {
+		lg.Fatalf("Failed to create output writer: %s\n", err)
+	}
 
-			for {
-				// Get a new context to analyze
-				workCtx, more := <-workChan
-				if !more {
-					break
-				}
-				match.context = workCtx
-
-				// Gather a list of all outliers in this sub-population
-				match.outlierList = match.outlierList[:0]
-				thisContextMatches := false
-				FindOutliers(db, lof, cache, im, workCtx, func(employee *Employee, score float64) bool {
-					foundMatch := employee == origOutlier
-					if foundMatch {
-						thisContextMatches = true
-					}
-					match.outlierList = append(match.outlierList, matchingOutlier{employee: employee, score: score})
-					return true
-				})
-				match.popSize = im.Count // Filled by FindOutliers call
-
-				// If the original outlier appears, send this context for printing
-				if thisContextMatches {
-					matchingContextChan <- match
-					// Wait until it gets printed before processing more work
-					// This prevents us from clobbering the outlier list buffer while it is being printed
-					<-match.printedNotice
-				}
+	scan := NewScan(db, lof, cfg, ctx, targets, out)
+	if scan.Estimate == nil && len(scan.Flips) >= 64 {
+		lg.Fatalf("Too many attribute values (%d) can be added to the original context for an exhaustive scan (try sampling)\n", len(scan.Flips))
+	}
+	if scan.Estimate != nil && cfg.Samples < uint64(len(scan.Estimate.Strata)) {
+		lg.Printf("Warning: %d samples cannot cover all %d strata, so the estimate is unbounded\n", cfg.Samples, len(scan.Estimate.Strata))
+	}
 
-				// Free up the context memory for reuse
-				ctxReuseChan <- workCtx
-			}
-		}()
+	shardFirst, endContext := scan.ShardRange(cfg.Shard, cfg.Shards)
+	firstContext := shardFirst
+	if cfg.Shards > 1 {
+		lg.Printf("Running shard %d of %d, which covers contexts %d to %d\n", cfg.Shard, cfg.Shards, firstContext, endContext)
 	}
+	if checkpoint != nil {
+		if err := checkpoint.Restore(db, scan); err != nil {
+			lg.Fatalf("Cannot resume from checkpoint: %s\n", err)
+		}
+		firstContext = checkpoint.NextContext
+		lg.Printf("Resuming from checkpoint at context %d with %d matching contexts found\n", firstContext, scan.Found)
+	} else {
+		// Record the parameters of this run so that output files are self-describing
+		out.WriteConfig(cfg)
 
-	// Goroutine for logging results
-	var foundContexts uint64
-	go func() {
-		defer func() { finishedChan <- struct{}{} }()
-		for {
-			match, more := <-matchingContextChan
-			if !more {
-				break
-			}
-			fmt.Fprintf(outFile, "Matching context with population size %d:\n", match.popSize)
-			match.context.WriteTo(outFile)
-			fmt.Fprintln(outFile, "Outliers in matching context:")
-			for _, outlier := range match.outlierList {
-				fmt.Fprintf(outFile, "  ID #%d with LOF %f\n", outlier.employee.Id, outlier.score)
-			}
-			fmt.Fprintln(outFile)
-			foundContexts++
+		// The output file lists the attributes so that the indices in contexts can be interpreted
+		out.WriteAttributes(db)
 
-			// Wake up the worker once more
-			match.printedNotice <- struct{}{}
-		}
-	}()
+		out.WriteOriginalContext(ctx)
+		out.WriteTargets(targets)
+	}
 
-	// We maintain a pool of allocated work contexts
-	// This allows workers to access the memory without being clobbered by the work assignment main thread
-	// Reusing these contexts avoids the main source of mallocs in the critical path
-	for i := 0; i < workerCount; i++ {
-		ctxReuseChan <- NewContext(db)
+	// Now try all other possible superset contexts to see if these records are still outliers
+	// We do this in parallel for performance
+	totalContexts := scan.Size()
+	if scan.Estimate != nil {
+		lg.Printf("Sampling %d of %.6g contexts with %d threads\n", totalContexts, scan.Estimate.Contexts, runtime.NumCPU())
+	} else {
+		// The original context is numbered too, but never scanned
+		lg.Printf("Scanning %d contexts with %d threads\n", totalContexts-1, runtime.NumCPU())
 	}
-	CleanRam(lg)
+	scanStartTime := time.Now()
+	checkpointFileName := CheckpointFileName(cfg)
+	scan.Run(lg, firstContext, endContext, time.Duration(cfg.CheckpointInterval), func(next uint64) {
+		offset, err := outFile.Cut()
+		if err != nil {
+			lg.Fatalf("Failed to flush output file for checkpoint: %s\n", err)
+		}
+		if err := NewCheckpoint(db, scan, next, offset).Save(checkpointFileName); err != nil {
+			lg.Fatalf("Failed to write checkpoint: %s\n", err)
+		}
+		lg.Printf("Checkpointed at context %d / %d\n", next, totalContexts)
+	})
 
-	// Enumerate all possible supersets by flipping unused attribute values through all permutations
-	lastPrint := time.Now()
-	var processedContexts uint64
-	originalContext := true
-	RecursivePermute(ctx.EmployersIncluded[OrigCtxEmployersCount:], func() {
-		RecursivePermute(ctx.JobTitlesIncluded[OrigCtxJobTitlesCount:], func() {
-			RecursivePermute(ctx.YearsIncluded[OrigCtxYearsCount:], func() {
-				// The first iteration is always unchanged from the start
-				if originalContext {
-					originalContext = false
-					return
-				}
+	lg.Printf("Found %d matching contexts in %s\n", scan.Found, time.Since(scanStartTime))
+	if cfg.Search == "pruned" {
+		lg.Printf("Evaluated %d contexts and skipped %d that cannot contain an outlier target\n", scan.Evaluated, scan.Skipped)
+	}
+	for reason, count := range scan.Unscored {
+		if count > 0 {
+			lg.Printf("%d contexts got no scores with %s\n", count, SkipReason(reason))
+		}
+	}
+	if scan.NonFinite > 0 {
+		lg.Printf("Warning: %d scores in the scanned contexts are NaN or infinite and were ignored\n", scan.NonFinite)
+	}
+	if est := scan.Estimate; est != nil {
+		est.CountSamples(shardFirst, endContext)
+		est.Compute()
+		lg.Printf("Estimated %.6g matching contexts (95%% CI %.6g to %.6g) out of %.6g, a fraction of %f (95%% CI %f to %f)\n",
+			est.Matches, est.MatchesLow, est.MatchesHigh, est.Contexts, est.Fraction, est.FractionLow, est.FractionHigh)
+		out.WriteEstimate(est)
+	}
 
-				// Get an empty work context
-				workCtx := <-ctxReuseChan
+	// Release one matching context per explained outlier with differential privacy
+	for _, target := range targets {
+		mechanism := target.Mechanism
+		lg.Printf("ID #%d is an outlier in %d matching contexts; selected private context with population size %d from %d candidates (epsilon %f, utility %s)\n",
+			target.Employee.Id, target.Matches, mechanism.SelectedPopSize, mechanism.Candidates, mechanism.Epsilon, mechanism.Utility.Name)
+	}
+	out.WriteSelections(targets)
+	if cfg.Shards > 1 {
+		if err := WriteSelectionKeys(SelectionKeysFileName(cfg.OutFile), targets); err != nil {
+			lg.Fatalf("Failed to write selection keys: %s\n", err)
+		}
+	}
 
-				// Dispatch the work context
-				workCtx.Copy(ctx)
-				workChan <- workCtx
+	// The end of the output is only written when it is closed, and the checkpoint must outlive any failure to do so
+	if err := outFile.Close(); err != nil {
+		lg.Fatalf("Failed to finish output file: %s\n", err)
+	}
 
-				processedContexts++
-				if time.Since(lastPrint) >= PrintFrequency {
-					lg.Printf("Processed %d / %d contexts (%.2f%%). %s\n", processedContexts, totalContexts, float64(processedContexts)/float64(totalContexts)*100.0, RamStats())
-					lastPrint = time.Now()
-				}
-			})
-		})
-	})
-	close(workChan)
-	for worker := 0; worker < workerCount; worker++ {
-		<-finishedChan
+	// The scan is complete, so there is nothing left to resume
+	if err := os.Remove(checkpointFileName); err != nil && !os.IsNotExist(err) {
+		lg.Printf("Failed to remove checkpoint: %s\n", err)
 	}
-	close(matchingContextChan)
-	<-finishedChan
+}
 
-	lg.Printf("Found %d matching contexts in %s\n", foundContexts, time.Since(scanStartTime))
+// NewNeighborIndex creates the nearest neighbor index selected by the configuration
+func NewNeighborIndex(cfg *Config, db *Database, metric Metric, lg *log.Logger) (NeighborIndex, error) {
+	switch cfg.NeighborIndex {
+	case "table":
+		// The table can be reused from an earlier run with the same database and metric
+		hash := KnnHash(db, cfg)
+		if cfg.NeighborCache != "" {
+			knn, err := LoadKnn(cfg.NeighborCache, db, metric, hash)
+			if err == nil {
+				lg.Printf("Loaded nearest neighbors from \"%s\"\n", cfg.NeighborCache)
+				return knn, nil
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		lg.Printf("Precomputing nearest neighbors for all records using the %s metric\n", cfg.Metric)
+		knn := NewKnn(db, metric, lg, time.Duration(cfg.PrintFrequency))
+		lg.Println("Completed nearest neighbor computation")
+		if cfg.NeighborCache != "" {
+			if err := knn.Save(cfg.NeighborCache, hash); err != nil {
+				return nil, errors.New(fmt.Sprintf("failed to save nearest neighbors: %s", err))
+			}
+			lg.Printf("Saved nearest neighbors to \"%s\"\n", cfg.NeighborCache)
+		}
+		return knn, nil
+	case "sorted":
+		lineMetric, ok := metric.(LineMetric)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("the sorted neighbor index cannot be used with the %s metric", cfg.Metric))
+		}
+		lg.Printf("Sorting all records using the %s metric\n", cfg.Metric)
+		return NewSortedKnn(db, lineMetric), nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown neighbor index \"%s\"", cfg.NeighborIndex))
 }
 
-func FindOutliers(db *Database, lof *Lof, cache *LofCache, im *InclusionMask, ctx *Context, outlierHandler OutlierHandler) {
-	inclusion := make(chan bool)
-	go db.Filter(ctx.EmployersIncluded, ctx.JobTitlesIncluded, ctx.YearsIncluded, inclusion)
-	im.Fill(inclusion)
+// SelectTarget picks the outlier to explain among the outliers of the original context. A non-negative targetId selects
+// the record by its ID, and a non-zero targetRank selects the record with that rank of score (1 being the highest).
+// Without either, the first outlier found is used.
+func SelectTarget(db *Database, origIm *InclusionMask, outliers []Outlier, targetId int64, targetRank uint) (Outlier, error) {
+	if targetId >= 0 {
+		for _, outlier := range outliers {
+			if outlier.Employee.Id == uint64(targetId) {
+				return outlier, nil
+			}
+		}
+		for i, employee := range db.Employees {
+			if employee.Id == uint64(targetId) {
+				if !origIm.IsIncluded(uint64(i)) {
+					return Outlier{}, errors.New(fmt.Sprintf("record #%d is not part of the original context", targetId))
+				}
+				return Outlier{}, errors.New(fmt.Sprintf("record #%d is not an outlier in the original context", targetId))
+			}
+		}
+		return Outlier{}, errors.New(fmt.Sprintf("record #%d is not in the database (after initial filtering)", targetId))
+	}
 
-	const MinPopulationSize = 20
-	if im.Count < MinPopulationSize {
-		return
+	if targetRank > 0 {
+		if targetRank > uint(len(outliers)) {
+			return Outlier{}, errors.New(fmt.Sprintf("cannot select rank %d because the original context only has %d outliers", targetRank, len(outliers)))
+		}
+		ranked := append([]Outlier(nil), outliers...)
+		sort.SliceStable(ranked, func(a, b int) bool { return ranked[a].Score > ranked[b].Score })
+		return ranked[targetRank-1], nil
 	}
 
-	lof.FindOutliers(cache, im, outlierHandler)
+	return outliers[0], nil
 }
 
-func RecursivePermute(slice []bool, handler func()) {
-	if len(slice) <= 0 {
-		handler()
+func FindOutliers(db *Database, detector Detector, im *InclusionMask, ctx *Context, minPopulationSize uint64, outlierHandler OutlierHandler) {
+	FilterContext(db, im, ctx)
+
+	if im.Count < minPopulationSize {
+		detector.Stats().Skips[SkipBelowMinPopulation]++
 		return
 	}
-	slice[0] = false
-	RecursivePermute(slice[1:], handler)
-	slice[0] = true
-	RecursivePermute(slice[1:], handler)
+
+	detector.FindOutliers(im, outlierHandler)
 }
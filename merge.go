@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// selectionKey is the perturbed score of the selection of a shard for one target, or null without candidates. Merging
+// needs it to combine the selections, but it holds the noise that the selection drew, so it is kept out of the output
+// in a file of its own.
+type selectionKey struct {
+	Target uint64   `json:"target"`
+	Key    *float64 `json:"key"`
+}
+
+// SelectionKeysFileName is the file of the selection keys of a shard, next to its output
+func SelectionKeysFileName(outFile string) string {
+	return fmt.Sprintf("%s.keys", outFile)
+}
+
+// WriteSelectionKeys writes the selection keys of the targets in order, as JSON Lines
+func WriteSelectionKeys(fileName string, targets []*Target) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, target := range targets {
+		record := selectionKey{Target: target.Employee.Id}
+		if target.Mechanism.Candidates > 0 {
+			key := target.Mechanism.BestKey
+			record.Key = &key
+		}
+		if err := enc.Encode(&record); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// readSelectionKeys reads the selection keys of the shard output in fileName
+func readSelectionKeys(fileName string) ([]selectionKey, error) {
+	keysFileName := SelectionKeysFileName(strings.TrimSuffix(fileName, ".gz"))
+	f, err := os.Open(keysFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys []selectionKey
+	dec := json.NewDecoder(f)
+	for {
+		var record selectionKey
+		if err := dec.Decode(&record); err == io.EOF {
+			return keys, nil
+		} else if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %s", keysFileName, err))
+		}
+		keys = append(keys, record)
+	}
+}
+
+// shardOutput is a JSON Lines output file of one shard of a scan
+type shardOutput struct {
+	fileName string
+	cfg      *Config
+	keys     []selectionKey
+	header   [][]byte // Setup records other than the configuration
+	r        *bufio.Reader
+	close    func()
+}
+
+func openShardOutput(fileName string) (*shardOutput, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, errors.New(fmt.Sprintf("%s: %s", fileName, err))
+	}
+	shard := &shardOutput{
+		fileName: fileName,
+		r:        bufio.NewReader(gz),
+		close:    func() { gz.Close(); f.Close() },
+	}
+
+	// The configuration always comes first and identifies the shard
+	line, recordType, err := shard.next()
+	if err == nil && recordType != "config" {
+		err = errors.New("output does not start with a configuration record (only jsonl outputs can be merged)")
+	}
+	if err == nil {
+		var record struct {
+			Config *Config `json:"config"`
+		}
+		err = json.Unmarshal(line, &record)
+		shard.cfg = record.Config
+	}
+	if err != nil {
+		shard.close()
+		return nil, errors.New(fmt.Sprintf("%s: %s", fileName, err))
+	}
+	if shard.keys, err = readSelectionKeys(fileName); err != nil {
+		shard.close()
+		return nil, err
+	}
+	return shard, nil
+}
+
+// next returns the next record and its type, or io.EOF at the end of the file
+func (shard *shardOutput) next() ([]byte, string, error) {
+	line, err := shard.r.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		return nil, "", errors.New("output ends with an incomplete record")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var record struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, "", err
+	}
+	return line, record.Type, nil
+}
+
+// mergeHash identifies the parameters that must be shared by all shards of a scan
+func mergeHash(cfg *Config) string {
+	relevant := *cfg
+	relevant.Shard = 0
+	return ConfigHash(&relevant)
+}
+
+// RunMerge implements the merge command, which combines the outputs of all shards of a scan into the output that a
+// single process would have produced. The private selections are combined by keeping the candidate with the largest
+// perturbed score, which is exactly the exponential mechanism over the union of the candidates. The perturbed scores
+// come from the selection keys next to each shard output and are not copied into the merged output.
+func RunMerge(args []string, lg *log.Logger) error {
+	if len(args) < 3 {
+		fmt.Fprintf(os.Stderr, "Usage: %s merge OUTFILE SHARDFILE...\n", os.Args[0])
+		os.Exit(1)
+	}
+	outFileName := args[1]
+
+	var shards []*shardOutput
+	defer func() {
+		for _, shard := range shards {
+			shard.close()
+		}
+	}()
+	for _, fileName := range args[2:] {
+		shard, err := openShardOutput(fileName)
+		if err != nil {
+			return err
+		}
+		shards = append(shards, shard)
+	}
+
+	// Every shard of the same scan must be present exactly once
+	sort.Slice(shards, func(a, b int) bool { return shards[a].cfg.Shard < shards[b].cfg.Shard })
+	if uint(len(shards)) != shards[0].cfg.Shards {
+		return errors.New(fmt.Sprintf("scan has %d shards but %d outputs were given", shards[0].cfg.Shards, len(shards)))
+	}
+	for i, shard := range shards {
+		if shard.cfg.Shard != uint(i) {
+			return errors.New(fmt.Sprintf("%s: duplicate output of shard %d", shard.fileName, shard.cfg.Shard))
+		}
+		if mergeHash(shard.cfg) != mergeHash(shards[0].cfg) {
+			return errors.New(fmt.Sprintf("%s: shard was run with a different configuration than %s", shard.fileName, shards[0].fileName))
+		}
+	}
+
+	outRaw, err := os.Create(fmt.Sprintf("%s.gz", outFileName))
+	if err != nil {
+		return err
+	}
+	defer outRaw.Close()
+	outFile := gzip.NewWriter(outRaw)
+	defer outFile.Close()
+	enc := json.NewEncoder(outFile)
+
+	merged := *shards[0].cfg
+	merged.OutFile = outFileName
+	merged.Shard = 0
+	merged.Shards = 1
+	enc.Encode(struct {
+		Type   string  `json:"type"`
+		Config *Config `json:"config"`
+	}{"config", &merged})
+
+	var selections []*jsonSelection
+	var keys []*float64
+	var estimate *Estimate
+	var foundContexts uint64
+	for i, shard := range shards {
+		var selectionCount int
+		for {
+			line, recordType, err := shard.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return errors.New(fmt.Sprintf("%s: %s", shard.fileName, err))
+			}
+
+			switch recordType {
+			case "match":
+				outFile.Write(line)
+				foundContexts++
+			case "estimate":
+				// The samples and matches of the strata add up
+				shardEstimate := &jsonEstimate{Estimate: &Estimate{}}
+				if err := json.Unmarshal(line, shardEstimate); err != nil {
+					return errors.New(fmt.Sprintf("%s: %s", shard.fileName, err))
+				}
+				if estimate == nil {
+					estimate = shardEstimate.Estimate
+				} else {
+					if len(shardEstimate.Strata) != len(estimate.Strata) {
+						return errors.New(fmt.Sprintf("%s: estimate has different strata than %s", shard.fileName, shards[0].fileName))
+					}
+					for i, stratum := range shardEstimate.Strata {
+						estimate.Strata[i].Samples += stratum.Samples
+						estimate.Strata[i].Matches += stratum.Matches
+					}
+				}
+			case "selection":
+				selection := &jsonSelection{}
+				if err := json.Unmarshal(line, selection); err != nil {
+					return errors.New(fmt.Sprintf("%s: %s", shard.fileName, err))
+				}
+				if selectionCount >= len(shard.keys) || shard.keys[selectionCount].Target != selection.Target {
+					return errors.New(fmt.Sprintf("%s: selection keys do not match the selections", shard.fileName))
+				}
+				key := shard.keys[selectionCount].Key
+				if i == 0 {
+					selections = append(selections, selection)
+					keys = append(keys, key)
+				} else {
+					if selectionCount >= len(selections) || selections[selectionCount].Target != selection.Target {
+						return errors.New(fmt.Sprintf("%s: selections do not match the targets of %s", shard.fileName, shards[0].fileName))
+					}
+					combined := selections[selectionCount]
+					combined.Matches += selection.Matches
+					combined.Candidates += selection.Candidates
+					if key != nil && (keys[selectionCount] == nil || *key > *keys[selectionCount]) {
+						combined.Score = selection.Score
+						combined.PopSize = selection.PopSize
+						combined.Context = selection.Context
+						keys[selectionCount] = key
+					}
+				}
+				selectionCount++
+			default:
+				// Setup records must be identical across shards
+				if selectionCount > 0 {
+					return errors.New(fmt.Sprintf("%s: unexpected %s record after the selections", shard.fileName, recordType))
+				}
+				if i == 0 {
+					shard.header = append(shard.header, line)
+					outFile.Write(line)
+				} else {
+					if len(shard.header) >= len(shards[0].header) || !bytes.Equal(line, shards[0].header[len(shard.header)]) {
+						return errors.New(fmt.Sprintf("%s: %s record differs from %s", shard.fileName, recordType, shards[0].fileName))
+					}
+					shard.header = append(shard.header, line)
+				}
+			}
+		}
+		if len(shard.header) != len(shards[0].header) {
+			return errors.New(fmt.Sprintf("%s: setup records differ from %s", shard.fileName, shards[0].fileName))
+		}
+		if selectionCount != len(selections) || selectionCount != len(shard.keys) {
+			return errors.New(fmt.Sprintf("%s: output is incomplete (the shard may not have finished)", shard.fileName))
+		}
+	}
+
+	if estimate != nil {
+		estimate.Compute()
+		enc.Encode(jsonEstimate{"estimate", estimate})
+		lg.Printf("Estimated %.6g matching contexts (95%% CI %.6g to %.6g) out of %.6g\n", estimate.Matches, estimate.MatchesLow, estimate.MatchesHigh, estimate.Contexts)
+	}
+	for _, selection := range selections {
+		enc.Encode(selection)
+		lg.Printf("ID #%d is an outlier in %d matching contexts; selected private context with population size %d from %d candidates\n",
+			selection.Target, selection.Matches, selection.PopSize, selection.Candidates)
+	}
+	// The deferred calls only clean up after errors, since the end of the output is written when it is closed
+	if err := outFile.Close(); err != nil {
+		return err
+	}
+	if err := outRaw.Close(); err != nil {
+		return err
+	}
+	lg.Printf("Merged %d shards with %d matching contexts into \"%s.gz\"\n", len(shards), foundContexts, outFileName)
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Metric measures how far one employee is from another. Distances must be non-negative, but need not be symmetric.
+// Implementations must be thread safe.
+type Metric interface {
+	Distance(from *Employee, to *Employee) Distance
+}
+
+// LineMetric is a metric whose distance is the absolute difference of the positions of the records on a line, which
+// allows the O(n) memory SortedKnn neighbor index
+type LineMetric interface {
+	Metric
+	Position(e *Employee) float64
+}
+
+// MetricWeights are the coefficients of the terms of a WeightedMetric, by attribute or distance column name. Distance
+// columns without a weight count once per unit of difference, and attributes without a weight are ignored.
+type MetricWeights map[string]float64
+
+// NewMetric creates one of the built-in metrics by name
+func NewMetric(name string, db *Database, weights MetricWeights) (Metric, error) {
+	switch name {
+	case "absolute":
+		return AbsoluteMetric{}, nil
+	case "logratio":
+		for _, employee := range db.Employees {
+			for i, amount := range employee.Distances {
+				if amount < 0 {
+					return nil, errors.New(fmt.Sprintf("the logratio metric needs amounts of at least 0, but ID #%d has %s %v",
+						employee.Id, db.Distances[i], amount))
+				}
+			}
+		}
+		return LogRatioMetric{}, nil
+	case "weighted":
+		return NewWeightedMetric(db, weights)
+	}
+	return nil, errors.New(fmt.Sprintf("unknown metric \"%s\"", name))
+}
+
+// AbsoluteMetric is the sum of the absolute differences of the distance columns. Records are on a line when there is a
+// single distance column.
+type AbsoluteMetric struct{}
+
+func (AbsoluteMetric) Distance(from *Employee, to *Employee) Distance {
+	var d float64
+	for i, amount := range from.Distances {
+		d += math.Abs(amount - to.Distances[i])
+	}
+	return Distance(d)
+}
+
+func (AbsoluteMetric) Position(e *Employee) float64 {
+	return e.Distances[0]
+}
+
+// LogRatioMetric is the sum of the absolute log-ratios of the distance columns, so that differences are relative to
+// the level of each amount. Amounts are offset by one so that zero amounts remain at a finite distance. Amounts must not
+// be negative, which NewMetric checks.
+type LogRatioMetric struct{}
+
+func (LogRatioMetric) Distance(from *Employee, to *Employee) Distance {
+	var d float64
+	for i, amount := range from.Distances {
+		d += math.Abs(math.Log((amount + 1) / (to.Distances[i] + 1)))
+	}
+	return Distance(d)
+}
+
+func (LogRatioMetric) Position(e *Employee) float64 {
+	return math.Log(e.Distances[0] + 1)
+}
+
+// WeightedMetric is a weighted sum of the differences of the distance columns and numeric attributes, and of
+// indicators of differing categorical attributes
+type WeightedMetric struct {
+	Db      *Database
+	Weights MetricWeights
+
+	distanceWeights  []float64 // By distance column
+	attributeWeights []float64 // By attribute
+}
+
+func NewWeightedMetric(db *Database, weights MetricWeights) (*WeightedMetric, error) {
+	wm := &WeightedMetric{
+		Db:               db,
+		Weights:          weights,
+		distanceWeights:  make([]float64, len(db.Distances)),
+		attributeWeights: make([]float64, len(db.Attributes)),
+	}
+	for i := range wm.distanceWeights {
+		wm.distanceWeights[i] = 1
+	}
+	for name, weight := range weights {
+		if i := indexOf(db.Distances, name); i >= 0 {
+			wm.distanceWeights[i] = weight
+		} else if i := db.Attribute(name); i >= 0 {
+			wm.attributeWeights[i] = weight
+		} else {
+			return nil, errors.New(fmt.Sprintf("metric weight of unknown attribute \"%s\"", name))
+		}
+	}
+	return wm, nil
+}
+
+func (wm *WeightedMetric) Distance(from *Employee, to *Employee) Distance {
+	var d float64
+	for i, weight := range wm.distanceWeights {
+		d += weight * math.Abs(from.Distances[i]-to.Distances[i])
+	}
+	for i, weight := range wm.attributeWeights {
+		if weight == 0 || from.Attributes[i] == to.Attributes[i] {
+			continue
+		}
+		if numbers := wm.Db.Attributes[i].Numbers; numbers != nil {
+			d += weight * math.Abs(numbers[from.Attributes[i]]-numbers[to.Attributes[i]])
+		} else {
+			d += weight
+		}
+	}
+	return Distance(d)
+}
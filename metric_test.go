@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLogRatioNeedsNonNegativeAmounts(t *testing.T) {
+	tests := []struct {
+		salaries []float64
+		valid    bool
+	}{
+		{[]float64{0, 100, 200}, true},
+		{[]float64{-0.5, 100, 200}, false},
+		{[]float64{-1, 100, 200}, false},
+		{[]float64{-500, 100, 200}, false},
+	}
+	for _, test := range tests {
+		db := salaryDatabase(t, 100, 100, 100)
+		for i, salary := range test.salaries {
+			db.Employees[i].Distances[0] = salary
+		}
+		metric, err := NewMetric("logratio", db, nil)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("the logratio metric accepts the amounts %v", test.salaries)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("the logratio metric rejects the amounts %v: %s", test.salaries, err)
+		}
+		for _, from := range db.Employees {
+			for _, to := range db.Employees {
+				if d := float64(metric.Distance(from, to)); math.IsNaN(d) || math.IsInf(d, 0) {
+					t.Errorf("the logratio distance from %v to %v is %v", from.Distances[0], to.Distances[0], d)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// oracleLof computes the LOF scores of the records of a subset by brute force, straight from the definitions: every
+// distance is computed again and the neighbors of each record are found by sorting all the others. It is the reference
+// that the tests compare Lof to, so it shares none of its code.
+//
+// Members are in index order and the sort is stable, so ties are broken by record index like the neighbor indices do,
+// unless the neighborhoods include all the ties. Reachability distances are at least minReachability.
+func oracleLof(db *Database, metric Metric, k uint64, tieInclusive bool, minReachability float64, members []uint64) []float64 {
+	m := len(members)
+	scores := make([]float64, m)
+	if m < 2 {
+		return scores
+	}
+
+	distance := func(a int, b int) float64 {
+		return float64(metric.Distance(db.Employees[members[a]], db.Employees[members[b]]))
+	}
+	neighbors := make([][]int, m)
+	kDistance := make([]float64, m)
+	row := make([]float64, m)
+	for p := 0; p < m; p++ {
+		others := make([]int, 0, m-1)
+		for o := 0; o < m; o++ {
+			row[o] = distance(p, o)
+			if o != p {
+				others = append(others, o)
+			}
+		}
+		sort.SliceStable(others, func(a, b int) bool { return row[others[a]] < row[others[b]] })
+		count := int(k)
+		if count > len(others) {
+			count = len(others)
+		}
+		kDistance[p] = row[others[count-1]]
+		for tieInclusive && count < len(others) && row[others[count]] == kDistance[p] {
+			count++
+		}
+		neighbors[p] = others[:count]
+	}
+
+	lrd := make([]float64, m)
+	for p := 0; p < m; p++ {
+		var sum float64
+		for _, o := range neighbors[p] {
+			reachability := distance(o, p)
+			if reachability < kDistance[o] {
+				reachability = kDistance[o]
+			}
+			if reachability < minReachability {
+				reachability = minReachability
+			}
+			sum += reachability
+		}
+		lrd[p] = float64(len(neighbors[p])) / sum
+	}
+
+	for p := 0; p < m; p++ {
+		var sum float64
+		for _, o := range neighbors[p] {
+			sum += lrd[o]
+		}
+		scores[p] = sum / (float64(len(neighbors[p])) * lrd[p])
+	}
+	return scores
+}
+
+// oracleMinReachability returns the floor of reachability distances: the smallest non-zero distance between two records
+// of the database by comparing every pair, or 1 when they all coincide
+func oracleMinReachability(db *Database, metric Metric) float64 {
+	min := math.Inf(1)
+	for _, a := range db.Employees {
+		for _, b := range db.Employees {
+			if distance := float64(metric.Distance(a, b)); distance > 0 && distance < min {
+				min = distance
+			}
+		}
+	}
+	if math.IsInf(min, 1) {
+		return 1
+	}
+	return min
+}
+
+// members lists the records included by an inclusion mask
+func members(im *InclusionMask, n int) []uint64 {
+	var out []uint64
+	for i := 0; i < n; i++ {
+		if im.IsIncluded(uint64(i)) {
+			out = append(out, uint64(i))
+		}
+	}
+	return out
+}
+
+// oracleKDistances computes the distance from each record of a subset to its k-th nearest other record, or to the
+// furthest when there are at most k records, by sorting the distances to all the others
+func oracleKDistances(db *Database, metric Metric, k uint64, members []uint64) []float64 {
+	kDistances := make([]float64, len(members))
+	if len(members) < 2 {
+		return kDistances
+	}
+	for p, i := range members {
+		distances := make([]float64, 0, len(members)-1)
+		for _, j := range members {
+			if j != i {
+				distances = append(distances, float64(metric.Distance(db.Employees[i], db.Employees[j])))
+			}
+		}
+		sort.Float64s(distances)
+		if k < uint64(len(distances)) {
+			kDistances[p] = distances[k-1]
+		} else {
+			kDistances[p] = distances[len(distances)-1]
+		}
+	}
+	return kDistances
+}
+
+// oracleAgrees compares a score computed by Lof to the score of the oracle
+func oracleAgrees(score float64, oracle float64) bool {
+	return score == oracle || math.Abs(score-oracle) <= 1e-9*math.Max(1, math.Abs(oracle))
+}
+
+// compareToOracle compares the LOF scores of every record of a subset to the brute force oracle, and returns the
+// number of scores compared. Scores must be finite.
+func compareToOracle(db *Database, lof *Lof, cache *LofCache, im *InclusionMask, minReachability float64) (int, error) {
+	cache.NonFinite = 0
+	skips := cache.Skips
+	scores := make(map[*Employee]float64, im.Count)
+	lof.FindOutliers(cache, im, func(employee *Employee, score float64) bool {
+		scores[employee] = score
+		return true
+	})
+	if cache.Skips != skips {
+		if len(scores) > 0 {
+			return 0, errors.New(fmt.Sprintf("%d records have scores in a skipped context of %d records", len(scores), im.Count))
+		}
+		return 0, nil
+	}
+	subset := members(im, len(db.Employees))
+	if cache.NonFinite > 0 {
+		return 0, errors.New(fmt.Sprintf("%d scores are NaN or infinite in a context of %d records", cache.NonFinite, im.Count))
+	}
+	oracleScores := oracleLof(db, lof.Metric, lof.K, lof.TieInclusive, minReachability, subset)
+	for p, i := range subset {
+		employee := db.Employees[i]
+		if !oracleAgrees(scores[employee], oracleScores[p]) {
+			return 0, errors.New(fmt.Sprintf("ID #%d has LOF %v but the oracle gives %v in a context of %d records",
+				employee.Id, scores[employee], oracleScores[p], len(subset)))
+		}
+	}
+	return len(subset), nil
+}
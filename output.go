@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ResultWriter records the setup and results of a context scan in some output format. Calls are made in the order of
+// the methods below; WriteMatch is called once per matching context. Implementations need not be thread safe.
+//
+// The output is a record of the experiment, not a private release: it has the matching contexts and exact counts. Only
+// the contexts selected by the exponential mechanism, which WriteSelections writes next to those counts, are
+// differentially private.
+type ResultWriter interface {
+	WriteConfig(cfg *Config)
+	WriteAttributes(db *Database)
+	WriteOriginalContext(ctx *Context)
+	WriteTargets(targets []*Target)
+	WriteMatch(match *MatchingContext, targets []*Target)
+	WriteEstimate(est *Estimate)
+	WriteSelections(targets []*Target)
+}
+
+// NewResultWriter creates a writer for an output format. The text format names the scores by scoreName, as given by
+// DetectorScoreName.
+func NewResultWriter(format string, scoreName string, w io.Writer) (ResultWriter, error) {
+	switch format {
+	case "text":
+		return &TextWriter{w: w, scoreName: scoreName}, nil
+	case "jsonl":
+		return &JsonLinesWriter{enc: json.NewEncoder(w)}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown output format \"%s\"", format))
+}
+
+// TextWriter produces a human readable report
+type TextWriter struct {
+	w         io.Writer
+	scoreName string
+	names     []string // Names of the attributes, from WriteAttributes
+}
+
+func (tw *TextWriter) WriteConfig(cfg *Config) {
+	fmt.Fprintln(tw.w, "Configuration:")
+	cfg.WriteJSON(tw.w)
+	fmt.Fprintln(tw.w)
+}
+
+func (tw *TextWriter) WriteAttributes(db *Database) {
+	tw.names = nil
+	for _, attribute := range db.Attributes {
+		tw.names = append(tw.names, attribute.Name)
+		fmt.Fprintf(tw.w, "%s:\n", attribute.Name)
+		for i, value := range attribute.Values {
+			fmt.Fprintf(tw.w, "  %d: %s\n", i, value)
+		}
+		fmt.Fprintln(tw.w)
+	}
+}
+
+func (tw *TextWriter) WriteOriginalContext(ctx *Context) {
+	fmt.Fprintln(tw.w, "Original context:")
+	ctx.WriteTo(tw.w)
+	fmt.Fprintln(tw.w)
+}
+
+func (tw *TextWriter) WriteTargets(targets []*Target) {
+	for _, target := range targets {
+		fmt.Fprintf(tw.w, "Original outlier with %s %f: ID #%d", tw.scoreName, target.Score, target.Employee.Id)
+		for dimension, value := range target.Employee.Attributes {
+			fmt.Fprintf(tw.w, ", %s %d", tw.names[dimension], value)
+		}
+		fmt.Fprintln(tw.w)
+	}
+	fmt.Fprintln(tw.w)
+}
+
+func (tw *TextWriter) WriteMatch(match *MatchingContext, targets []*Target) {
+	fmt.Fprintf(tw.w, "Matching context with population size %d:\n", match.PopSize)
+	match.Context.WriteTo(tw.w)
+	if len(targets) > 1 {
+		fmt.Fprintln(tw.w, "Explained outliers in matching context:")
+		for _, target := range match.TargetList {
+			fmt.Fprintf(tw.w, "  ID #%d\n", targets[target].Employee.Id)
+		}
+	}
+	fmt.Fprintln(tw.w, "Outliers in matching context:")
+	for _, outlier := range match.OutlierList {
+		fmt.Fprintf(tw.w, "  ID #%d with %s %f\n", outlier.Employee.Id, tw.scoreName, outlier.Score)
+	}
+	fmt.Fprintln(tw.w)
+}
+
+func (tw *TextWriter) WriteEstimate(est *Estimate) {
+	fmt.Fprintf(tw.w, "Estimated matching contexts: %.6g (95%% CI %.6g to %.6g) out of %.6g\n", est.Matches, est.MatchesLow, est.MatchesHigh, est.Contexts)
+	fmt.Fprintf(tw.w, "Estimated fraction of matching contexts: %f (95%% CI %f to %f)\n", est.Fraction, est.FractionLow, est.FractionHigh)
+	for _, stratum := range est.Strata {
+		if stratum.Size > 0 {
+			fmt.Fprintf(tw.w, "  %d added attribute values: %d of %d samples match\n", stratum.Size, stratum.Matches, stratum.Samples)
+		} else {
+			fmt.Fprintf(tw.w, "  %d of %d samples match\n", stratum.Matches, stratum.Samples)
+		}
+	}
+	fmt.Fprintln(tw.w)
+}
+
+func (tw *TextWriter) WriteSelections(targets []*Target) {
+	for _, target := range targets {
+		mechanism := target.Mechanism
+		fmt.Fprintf(tw.w, "Outlier ID #%d is an outlier in %d matching contexts (exact, not private)\n", target.Employee.Id, target.Matches)
+		fmt.Fprintf(tw.w, "Private context selected by the exponential mechanism from %d candidates with epsilon %f, utility %s (score %f), and sensitivity %f, with population size %d:\n",
+			mechanism.Candidates, mechanism.Epsilon, mechanism.Utility.Name, mechanism.SelectedScore, mechanism.Utility.Sensitivity, mechanism.SelectedPopSize)
+		mechanism.Selected.WriteTo(tw.w)
+		fmt.Fprintln(tw.w)
+	}
+}
+
+// JsonLinesWriter produces one JSON object per line. Every object has a "type" field naming the kind of record.
+type JsonLinesWriter struct {
+	enc   *json.Encoder
+	names []string // Names of the attributes, from WriteAttributes
+}
+
+// Shapes of the JSON Lines records
+
+// jsonContext lists the indices of the included values of each attribute by name
+type jsonContext map[string][]int
+
+type jsonEmployee struct {
+	Id         uint64          `json:"id"`
+	Score      float64         `json:"score"`
+	Attributes map[string]uint `json:"attributes"`
+}
+
+type jsonAttribute struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+type jsonOutlier struct {
+	Id    uint64  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// jsonSelection is the private context selected for a target. Its counts and population size are exact, not private.
+type jsonSelection struct {
+	Type        string      `json:"type"`
+	Target      uint64      `json:"target"`
+	Matches     uint64      `json:"matches"`
+	Candidates  uint64      `json:"candidates"`
+	Epsilon     float64     `json:"epsilon"`
+	Utility     string      `json:"utility"`
+	Sensitivity float64     `json:"sensitivity"`
+	Score       float64     `json:"score"`
+	PopSize     uint64      `json:"popSize"`
+	Context     jsonContext `json:"context"`
+}
+
+func newJsonContext(ctx *Context) jsonContext {
+	indices := func(arr []bool) []int {
+		out := make([]int, 0)
+		for i, included := range arr {
+			if included {
+				out = append(out, i)
+			}
+		}
+		return out
+	}
+	jc := make(jsonContext, len(ctx.Included))
+	for dimension, included := range ctx.Included {
+		jc[ctx.Names[dimension]] = indices(included)
+	}
+	return jc
+}
+
+// apply overwrites ctx with the attribute values listed in jc
+func (jc jsonContext) apply(ctx *Context) error {
+	if len(jc) != len(ctx.Included) {
+		return errors.New(fmt.Sprintf("context has %d attributes instead of %d", len(jc), len(ctx.Included)))
+	}
+	for dimension, arr := range ctx.Included {
+		indices, ok := jc[ctx.Names[dimension]]
+		if !ok {
+			return errors.New(fmt.Sprintf("context is missing attribute \"%s\"", ctx.Names[dimension]))
+		}
+		for i := range arr {
+			arr[i] = false
+		}
+		for _, i := range indices {
+			if i < 0 || i >= len(arr) {
+				return errors.New(fmt.Sprintf("attribute value index %d is out of range", i))
+			}
+			arr[i] = true
+		}
+	}
+	return nil
+}
+
+func (jw *JsonLinesWriter) WriteConfig(cfg *Config) {
+	jw.enc.Encode(struct {
+		Type   string  `json:"type"`
+		Config *Config `json:"config"`
+	}{"config", cfg})
+}
+
+func (jw *JsonLinesWriter) WriteAttributes(db *Database) {
+	attributes := make([]jsonAttribute, len(db.Attributes))
+	jw.names = nil
+	for i, attribute := range db.Attributes {
+		attributes[i] = jsonAttribute{Name: attribute.Name, Values: attribute.Values}
+		jw.names = append(jw.names, attribute.Name)
+	}
+	jw.enc.Encode(struct {
+		Type         string          `json:"type"`
+		Attributes   []jsonAttribute `json:"attributes"`
+		Distances    []string        `json:"distances"`
+		DatabaseHash string          `json:"databaseHash"`
+	}{"attributes", attributes, db.Distances, db.Hash()})
+}
+
+func (jw *JsonLinesWriter) WriteOriginalContext(ctx *Context) {
+	jw.enc.Encode(struct {
+		Type    string      `json:"type"`
+		Context jsonContext `json:"context"`
+	}{"originalContext", newJsonContext(ctx)})
+}
+
+func (jw *JsonLinesWriter) WriteTargets(targets []*Target) {
+	for _, target := range targets {
+		outlier := jsonEmployee{
+			Id:         target.Employee.Id,
+			Score:      target.Score,
+			Attributes: make(map[string]uint, len(target.Employee.Attributes)),
+		}
+		for dimension, value := range target.Employee.Attributes {
+			outlier.Attributes[jw.names[dimension]] = value
+		}
+		jw.enc.Encode(struct {
+			Type    string       `json:"type"`
+			Outlier jsonEmployee `json:"outlier"`
+		}{"target", outlier})
+	}
+}
+
+func (jw *JsonLinesWriter) WriteMatch(match *MatchingContext, targets []*Target) {
+	targetIds := make([]uint64, len(match.TargetList))
+	for i, target := range match.TargetList {
+		targetIds[i] = targets[target].Employee.Id
+	}
+	outliers := make([]jsonOutlier, len(match.OutlierList))
+	for i, outlier := range match.OutlierList {
+		outliers[i] = jsonOutlier{Id: outlier.Employee.Id, Score: outlier.Score}
+	}
+	jw.enc.Encode(struct {
+		Type     string        `json:"type"`
+		PopSize  uint64        `json:"popSize"`
+		Context  jsonContext   `json:"context"`
+		Targets  []uint64      `json:"targets"`
+		Outliers []jsonOutlier `json:"outliers"`
+	}{"match", match.PopSize, newJsonContext(match.Context), targetIds, outliers})
+}
+
+type jsonEstimate struct {
+	Type string `json:"type"`
+	*Estimate
+}
+
+func (jw *JsonLinesWriter) WriteEstimate(est *Estimate) {
+	jw.enc.Encode(jsonEstimate{"estimate", est})
+}
+
+func (jw *JsonLinesWriter) WriteSelections(targets []*Target) {
+	for _, target := range targets {
+		mechanism := target.Mechanism
+		selection := jsonSelection{
+			Type:        "selection",
+			Target:      target.Employee.Id,
+			Matches:     target.Matches,
+			Candidates:  mechanism.Candidates,
+			Epsilon:     mechanism.Epsilon,
+			Utility:     mechanism.Utility.Name,
+			Sensitivity: mechanism.Utility.Sensitivity,
+			Score:       mechanism.SelectedScore,
+			PopSize:     mechanism.SelectedPopSize,
+			Context:     newJsonContext(mechanism.Selected),
+		}
+		jw.enc.Encode(&selection)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Multiple of k up to which candidate neighbors are examined when bounding a score. Groups with more candidates are not
+// bounded.
+const pruneCandidateFactor = 8
+
+// Relative margin below the threshold that a bound must reach, which absorbs rounding differences between the distances
+// of the metric and of the neighbor index
+const pruneMargin = 1e-9
+
+// Pruner rules out groups of supersets in which no target can be an outlier. A group is an aligned block of the
+// enumeration, in which the first flippable values are fixed and the others are free. Every context of the group lies
+// between the smallest one (all free values excluded) and the largest one (all free values included), so k-distances
+// lie between their values in the largest context and in the smallest one.
+//
+// With N(x) the k nearest neighbors of a record x in some context of the group, LOF(t) of a target t is the mean LRD
+// over N(t) times the mean reachability distance from t over N(t), and both are bounded:
+//   - N(x) lies within the k-distance of x in the smallest context, among the records of the largest context
+//   - the reachability distance from t to a candidate o is at most the larger of d(o, t) and the k-distance of o in the
+//     smallest context
+//   - the LRD of a candidate o is at most k divided by the sum of the k smallest lower bounds on reachability distances
+//     from o, which are the larger of d(p, o) and the k-distance of p in the largest context for its candidates p
+//
+// Every reachability distance is also at least Lof.MinReachability, which the bounds apply in the same way.
+//
+// The bounds assume a symmetric metric, which all the built-in metrics are.
+type Pruner struct {
+	Scan *Scan
+
+	ctx        *Context
+	minIm      *InclusionMask
+	maxIm      *InclusionMask
+	targets    []uint64 // Record indices of the targets
+	neighbors  []uint64
+	candidates [2][]uint64 // Candidate neighbors of a target and of one of its candidates
+
+	// Memoized per group, valid when the stamp matches the group number
+	group     uint64
+	minStamp  []uint64
+	minRadius []Distance // k-distance in the smallest context by the distance of the neighbor index
+	minCore   []float64  // k-distance in the smallest context
+	maxStamp  []uint64
+	maxCore   []float64 // k-distance in the largest context
+	lrdStamp  []uint64
+	lrdBound  []float64
+
+	// Buffers of the reachability distances of maxLrd and of Bound, and of the LRD bounds of Bound
+	reach       []float64
+	targetReach []float64
+	lrdBounds   []float64
+}
+
+func NewPruner(scan *Scan) *Pruner {
+	n := len(scan.Db.Employees)
+	p := &Pruner{
+		Scan:      scan,
+		ctx:       NewContext(scan.Db),
+		minIm:     NewInclusionMask(scan.Db),
+		maxIm:     NewInclusionMask(scan.Db),
+		neighbors: make([]uint64, scan.Lof.K),
+		minStamp:  make([]uint64, n),
+		minRadius: make([]Distance, n),
+		minCore:   make([]float64, n),
+		maxStamp:  make([]uint64, n),
+		maxCore:   make([]float64, n),
+		lrdStamp:  make([]uint64, n),
+		lrdBound:  make([]float64, n),
+	}
+	for i := range p.candidates {
+		p.candidates[i] = make([]uint64, pruneCandidateFactor*scan.Lof.K)
+	}
+	for i, employee := range scan.Db.Employees {
+		if _, isTarget := scan.targetIndices[employee]; isTarget {
+			p.targets = append(p.targets, uint64(i))
+		}
+	}
+	return p
+}
+
+// Runs divides the contexts [first, end) into runs that are either skipped or scanned, in order. Groups are split until
+// they can be ruled out or hold a single context, where the bounds are tightest, and the contexts that are not ruled out
+// are scanned in runs of consecutive contexts no longer than the run length of the scan.
+func (p *Pruner) Runs(first uint64, end uint64, emit func(run scanRun, skip bool)) {
+	pending := scanRun{first: first, end: first}
+	p.visit(0, uint(len(p.Scan.Flips)), first, end, func(run scanRun, skip bool) {
+		if skip {
+			if pending.end > pending.first {
+				emit(pending, false)
+			}
+			emit(run, true)
+			pending = scanRun{first: run.end, end: run.end}
+			return
+		}
+		pending.end = run.end
+		if pending.end-pending.first >= scanRunLength {
+			emit(pending, false)
+			pending = scanRun{first: run.end, end: run.end}
+		}
+	})
+	if pending.end > pending.first {
+		emit(pending, false)
+	}
+}
+
+func (p *Pruner) visit(prefix uint64, level uint, first uint64, end uint64, emit func(run scanRun, skip bool)) {
+	run := scanRun{first: prefix << level, end: (prefix + 1) << level}
+	if run.end <= first || run.first >= end {
+		return
+	}
+	if run.first < first {
+		run.first = first
+	}
+	if run.end > end {
+		run.end = end
+	}
+
+	if p.CanSkip(prefix, level) {
+		emit(run, true)
+	} else if level == 0 {
+		emit(run, false)
+	} else {
+		p.visit(prefix*2, level-1, first, end, emit)
+		p.visit(prefix*2+1, level-1, first, end, emit)
+	}
+}
+
+// CanSkip reports whether no target can be an outlier in any context of the group of 2^level contexts starting at
+// context prefix * 2^level
+func (p *Pruner) CanSkip(prefix uint64, level uint) bool {
+	p.setGroup(prefix, level)
+	if p.minIm.Count <= p.Scan.Lof.K {
+		return false
+	}
+	for _, t := range p.targets {
+		if !(p.Bound(t) < p.Scan.Lof.Threshold*(1-pruneMargin)) {
+			return false
+		}
+	}
+	return true
+}
+
+// setGroup fills the inclusion masks of the smallest and largest contexts of a group
+func (p *Pruner) setGroup(prefix uint64, level uint) {
+	scan := p.Scan
+	p.group++
+	fixed := len(scan.Flips) - int(level)
+	scan.SetContext(p.ctx, prefix<<level)
+	for _, flip := range scan.Flips[fixed:] {
+		p.ctx.Included[flip.Dimension][flip.Value] = false
+	}
+	FilterContext(scan.Db, p.minIm, p.ctx)
+	for _, flip := range scan.Flips[fixed:] {
+		p.ctx.Included[flip.Dimension][flip.Value] = true
+	}
+	FilterContext(scan.Db, p.maxIm, p.ctx)
+}
+
+// minKDistance finds the k-distance of record x in the smallest context of the group
+func (p *Pruner) minKDistance(x uint64) {
+	if p.minStamp[x] == p.group {
+		return
+	}
+	lof := p.Scan.Lof
+	lof.Neighbors.KNearest(p.minIm, x, p.neighbors)
+	furthest := p.neighbors[lof.K-1]
+	p.minRadius[x] = lof.Neighbors.Distance(x, furthest)
+	p.minCore[x] = float64(lof.Metric.Distance(p.Scan.Db.Employees[x], p.Scan.Db.Employees[furthest]))
+	p.minStamp[x] = p.group
+}
+
+// maxKDistance returns the k-distance of record x in the largest context of the group
+func (p *Pruner) maxKDistance(x uint64) float64 {
+	if p.maxStamp[x] != p.group {
+		lof := p.Scan.Lof
+		lof.Neighbors.KNearest(p.maxIm, x, p.neighbors)
+		p.maxCore[x] = float64(lof.Metric.Distance(p.Scan.Db.Employees[x], p.Scan.Db.Employees[p.neighbors[lof.K-1]]))
+		p.maxStamp[x] = p.group
+	}
+	return p.maxCore[x]
+}
+
+// withinRadius fills candidates with the records of the largest context that are within the k-distance of x in the
+// smallest context, nearest first. It returns false if there are too many of them.
+func (p *Pruner) withinRadius(x uint64, candidates []uint64) ([]uint64, bool) {
+	lof := p.Scan.Lof
+	p.minKDistance(x)
+	count := lof.Neighbors.KNearest(p.maxIm, x, candidates)
+	if count == uint64(len(candidates)) && lof.Neighbors.Distance(x, candidates[count-1]) <= p.minRadius[x] {
+		return nil, false
+	}
+	for i, o := range candidates[:count] {
+		if lof.Neighbors.Distance(x, o) > p.minRadius[x] {
+			return candidates[:i], true
+		}
+	}
+	return candidates[:count], true
+}
+
+// sumOfK adds up the k largest or smallest values
+func sumOfK(values []float64, k uint64, largest bool) float64 {
+	if largest {
+		sort.Sort(sort.Reverse(sort.Float64Slice(values)))
+	} else {
+		sort.Float64s(values)
+	}
+	var sum float64
+	for _, value := range values[:k] {
+		sum += value
+	}
+	return sum
+}
+
+// maxLrd returns an upper bound on the LRD of record o in the current group
+func (p *Pruner) maxLrd(o uint64) float64 {
+	if p.lrdStamp[o] == p.group {
+		return p.lrdBound[o]
+	}
+	lof := p.Scan.Lof
+	employees := p.Scan.Db.Employees
+
+	p.reach = p.reach[:0]
+	candidates, ok := p.withinRadius(o, p.candidates[1])
+	if ok {
+		for _, q := range candidates {
+			reach := float64(lof.Metric.Distance(employees[q], employees[o]))
+			if core := p.maxKDistance(q); core > reach {
+				reach = core
+			}
+			p.reach = append(p.reach, math.Max(reach, float64(lof.MinReachability)))
+		}
+	} else {
+		// Without the candidates, the reachability distances are still at least the distances to the nearest records
+		lof.Neighbors.KNearest(p.maxIm, o, p.neighbors)
+		for _, q := range p.neighbors {
+			reach := float64(lof.Metric.Distance(employees[q], employees[o]))
+			p.reach = append(p.reach, math.Max(reach, float64(lof.MinReachability)))
+		}
+	}
+	p.lrdBound[o] = float64(lof.K) / sumOfK(p.reach, lof.K, false)
+	p.lrdStamp[o] = p.group
+	return p.lrdBound[o]
+}
+
+// Bound returns an upper bound on the LOF score of record t in the current group, or +Inf when it cannot be bounded. The
+// smallest context of the group must have more than k records.
+func (p *Pruner) Bound(t uint64) float64 {
+	lof := p.Scan.Lof
+	employees := p.Scan.Db.Employees
+
+	candidates, ok := p.withinRadius(t, p.candidates[0])
+	if !ok {
+		return math.Inf(1)
+	}
+	p.lrdBounds = p.lrdBounds[:0]
+	p.targetReach = p.targetReach[:0]
+	for _, o := range candidates {
+		p.lrdBounds = append(p.lrdBounds, p.maxLrd(o))
+		p.minKDistance(o)
+		r := float64(lof.Metric.Distance(employees[o], employees[t]))
+		if p.minCore[o] > r {
+			r = p.minCore[o]
+		}
+		p.targetReach = append(p.targetReach, math.Max(r, float64(lof.MinReachability)))
+	}
+
+	// The neighborhood of t is some k of the candidates
+	k := float64(lof.K)
+	bound := sumOfK(p.lrdBounds, lof.K, true) / k * sumOfK(p.targetReach, lof.K, true) / k
+	if math.IsNaN(bound) {
+		return math.Inf(1)
+	}
+	return bound
+}
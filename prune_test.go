@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// pruneTestEnv is a database in which record #1 earns far more than the others at employer A, but about as much as
+// everyone at employer B. Record #1 is an outlier in every superset of the original context (employer A in 2010) that
+// leaves out employer B, and in none of those that include it.
+func pruneTestEnv(t *testing.T) *testEnv {
+	var csv bytes.Buffer
+	fmt.Fprintln(&csv, ",Employer,Job Title,Salary Paid,Calendar Year")
+	fmt.Fprintln(&csv, "1,A,T,$200000,2010")
+	id := 2
+	add := func(employer string, year int, salary float64, step float64) {
+		for i := 0; i < 20; i++ {
+			fmt.Fprintf(&csv, "%d,%s,T,$%.2f,%d\n", id, employer, salary+step*float64(i), year)
+			id++
+		}
+	}
+	add("A", 2010, 100000, 1000)
+	add("A", 2011, 100500, 1000)
+	add("B", 2010, 190000, 1000)
+	add("C", 2010, 60000, 1000)
+	add("D", 2011, 50000, 500)
+
+	cfg := testConfig()
+	cfg.K = 5
+	cfg.MinPopulationSize = 10
+	cfg.Original = []OriginalAttribute{
+		{Name: "Employer", Values: []string{"A"}},
+		{Name: "Job Title", Values: []string{"T"}},
+		{Name: "Calendar Year", Values: []string{"2010"}},
+	}
+	return newCsvTestEnv(t, &csv, cfg)
+}
+
+// comparePrunedScan compares the matching contexts of the pruned search for the targets to the exhaustive search, and
+// returns the pruned scan
+func comparePrunedScan(t *testing.T, env *testEnv, targets []*Target) *Scan {
+	t.Helper()
+	cfg := *env.Cfg
+	cfg.Search = "exhaustive"
+	_, exhaustive := env.scanMatches(&cfg, targets)
+	for _, target := range targets {
+		target.Matches = 0
+		target.Mechanism = NewTarget(env.Db, target.Outlier, env.Cfg).Mechanism
+	}
+	cfg.Search = "pruned"
+	scan, pruned := env.scanMatches(&cfg, targets)
+	// Every superset but the original context itself
+	if scan.Evaluated+scan.Skipped != scan.TotalContexts()-1 {
+		t.Fatalf("pruned search evaluated %d and skipped %d of %d contexts", scan.Evaluated, scan.Skipped, scan.TotalContexts()-1)
+	}
+	if len(pruned) != len(exhaustive) {
+		t.Fatalf("pruned search found %d matching contexts but exhaustive search found %d", len(pruned), len(exhaustive))
+	}
+	for i := range exhaustive {
+		if pruned[i] != exhaustive[i] {
+			t.Fatalf("pruned search found a different matching context:\n%s\ninstead of:\n%s", pruned[i], exhaustive[i])
+		}
+	}
+	return scan
+}
+
+func TestPrunedSearchSkipsContexts(t *testing.T) {
+	env := pruneTestEnv(t)
+	targets := env.targets()
+	if len(targets) != 1 || targets[0].Employee.Id != 1 {
+		t.Fatalf("expected ID #1 to be the only outlier of the original context, got %d outliers", len(targets))
+	}
+	scan := comparePrunedScan(t, env, targets)
+	// Half of the supersets include employer B
+	if scan.Skipped != scan.TotalContexts()/2 {
+		t.Errorf("pruned search skipped %d of %d contexts, but %d cannot contain an outlier target",
+			scan.Skipped, scan.TotalContexts()-1, scan.TotalContexts()/2)
+	}
+}
+
+func TestPrunedSearchMatchesExhaustive(t *testing.T) {
+	forEachSeed(t, func(t *testing.T, env *testEnv) {
+		targets := env.targets()
+		if len(targets) == 0 {
+			t.Fatal("the original context has no outliers")
+		}
+		// Every target on its own, then all of them together
+		for i := 0; i <= len(targets); i++ {
+			subset := targets
+			if i < len(targets) {
+				subset = []*Target{NewTarget(env.Db, targets[i].Outlier, env.Cfg)}
+			}
+			comparePrunedScan(t, env, subset)
+		}
+	})
+}
+
+func TestPruneBoundsHold(t *testing.T) {
+	forEachSeed(t, func(t *testing.T, env *testEnv) {
+		scan := NewScan(env.Db, env.Lof, env.Cfg, env.Original, env.targets(), nil)
+		pruner := NewPruner(scan)
+		lof := *env.Lof
+		lof.Threshold = 0 // Every score is compared to the bounds
+
+		im := NewInclusionMask(env.Db)
+		cache := lof.NewThreadCache()
+		ctx := NewContext(env.Db)
+		n := uint(len(scan.Flips))
+		bounds := make(map[*Employee]float64, len(pruner.targets))
+		// Every group of every level, with every context in it
+		for level := uint(0); level <= n; level++ {
+			for prefix := uint64(0); prefix < 1<<(n-level); prefix++ {
+				pruner.setGroup(prefix, level)
+				if pruner.minIm.Count <= lof.K {
+					continue
+				}
+				for _, target := range pruner.targets {
+					bounds[env.Db.Employees[target]] = pruner.Bound(target)
+				}
+				for index := prefix << level; index < (prefix+1)<<level; index++ {
+					scan.SetContext(ctx, index)
+					FilterContext(env.Db, im, ctx)
+					if im.Count < env.Cfg.MinPopulationSize {
+						continue
+					}
+					lof.FindOutliers(cache, im, func(employee *Employee, score float64) bool {
+						if bound, isTarget := bounds[employee]; isTarget && score > bound*(1+pruneMargin) {
+							t.Fatalf("ID #%d has LOF %v in context %d, above the bound %v of its group of %d contexts",
+								employee.Id, score, index, bound, uint64(1)<<level)
+						}
+						return true
+					})
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"math/rand"
+)
+
+// Normal quantile of the two-sided 95% confidence intervals of estimates
+const estimateZ = 1.959964
+
+// Stratum is a set of supersets that is sampled separately, without replacement. Stratified sampling has one stratum per
+// number of added attribute values, and uniform sampling has a single stratum of every superset.
+type Stratum struct {
+	Size     int     `json:"size"`     // Number of added attribute values, or 0 for every superset
+	Contexts float64 `json:"contexts"` // Number of supersets in the stratum, which may exceed the range of integers
+	Samples  uint64  `json:"samples"`
+	Matches  uint64  `json:"matches"`
+
+	count   uint64 // Exact number of supersets in the stratum, when counted
+	counted bool   // Whether the number of supersets fits in a uint64
+}
+
+// Estimate is an estimate of the number of matching supersets of the original context from a sample of them
+type Estimate struct {
+	Strata []Stratum `json:"strata"`
+
+	Contexts     float64 `json:"contexts"` // Number of supersets, excluding the original context
+	Matches      float64 `json:"matches"`
+	MatchesLow   float64 `json:"matchesLow"`
+	MatchesHigh  float64 `json:"matchesHigh"`
+	Fraction     float64 `json:"fraction"`
+	FractionLow  float64 `json:"fractionLow"`
+	FractionHigh float64 `json:"fractionHigh"`
+}
+
+func NewEstimate(flips int, stratified bool) *Estimate {
+	est := &Estimate{Contexts: math.Exp2(float64(flips)) - 1}
+	if !stratified {
+		stratum := Stratum{Contexts: est.Contexts}
+		if flips <= 64 {
+			stratum.count, stratum.counted = ^uint64(0)>>uint(64-flips), true
+		}
+		est.Strata = []Stratum{stratum}
+		return est
+	}
+	for size := 1; size <= flips; size++ {
+		stratum := Stratum{Size: size, Contexts: binomial(flips, size)}
+		stratum.count, stratum.counted = exactBinomial(uint64(flips), uint64(size))
+		est.Strata = append(est.Strata, stratum)
+	}
+	return est
+}
+
+// binomial returns n choose k as a float, computed through logarithms so that it does not overflow
+func binomial(n int, k int) float64 {
+	lgN, _ := math.Lgamma(float64(n + 1))
+	lgK, _ := math.Lgamma(float64(k + 1))
+	lgNK, _ := math.Lgamma(float64(n - k + 1))
+	return math.Round(math.Exp(lgN - lgK - lgNK))
+}
+
+// exactBinomial returns n choose k, or false when it does not fit in a uint64
+func exactBinomial(n uint64, k uint64) (uint64, bool) {
+	// C(n - k + i, i) grows with i, so once it overflows, so does the result
+	c := uint64(1)
+	for i := uint64(1); i <= k; i++ {
+		hi, lo := bits.Mul64(c, n-k+i)
+		if hi >= i {
+			return 0, false
+		}
+		c, _ = bits.Div64(hi, lo, i)
+	}
+	return c, true
+}
+
+// Stratum returns the stratum of sample number index. Samples are assigned to the strata in turn, so that any range of
+// samples is spread evenly over the strata.
+func (est *Estimate) Stratum(index uint64) int {
+	return int(index % uint64(len(est.Strata)))
+}
+
+// ordinal returns the number of the draw of sample number index within its stratum
+func (est *Estimate) ordinal(index uint64) uint64 {
+	return index / uint64(len(est.Strata))
+}
+
+// Drawn reports whether sample number index draws a superset. Once every superset of a stratum has been drawn, the
+// later samples of the stratum draw nothing.
+func (est *Estimate) Drawn(index uint64) bool {
+	stratum := &est.Strata[est.Stratum(index)]
+	return !stratum.counted || est.ordinal(index) < stratum.count
+}
+
+// CountSamples sets the number of samples of every stratum for the samples [first, end) that draw a superset
+func (est *Estimate) CountSamples(first uint64, end uint64) {
+	strata := uint64(len(est.Strata))
+	for i := range est.Strata {
+		// Samples i, i + strata, i + 2 * strata, ... below a bound, up to the size of the stratum
+		below := func(bound uint64) uint64 {
+			if bound <= uint64(i) {
+				return 0
+			}
+			drawn := (bound-uint64(i)-1)/strata + 1
+			if est.Strata[i].counted && drawn > est.Strata[i].count {
+				drawn = est.Strata[i].count
+			}
+			return drawn
+		}
+		est.Strata[i].Samples = below(end) - below(first)
+	}
+}
+
+// Compute derives the estimate from the samples and matches of the strata. The number of matches of each stratum is
+// estimated from its fraction of matching samples, and the confidence interval uses the normal approximation with the
+// Agresti-Coull adjustment, which keeps the interval from collapsing when no or every sample matches. As the samples are
+// drawn without replacement, the variance shrinks by the finite population correction, down to zero for a stratum
+// whose every superset was drawn.
+func (est *Estimate) Compute() {
+	if est.Contexts == 0 {
+		// No value can be flipped, so there is no superset to estimate, and the fractions would be 0 / 0
+		est.Matches, est.MatchesLow, est.MatchesHigh = 0, 0, 0
+		est.Fraction, est.FractionLow, est.FractionHigh = 0, 0, 0
+		return
+	}
+	var matches, variance float64
+	for _, stratum := range est.Strata {
+		if stratum.Samples == 0 {
+			// An unsampled stratum could be anything
+			variance = math.Inf(1)
+			continue
+		}
+		samples := float64(stratum.Samples)
+		matches += stratum.Contexts * float64(stratum.Matches) / samples
+		adjusted := (float64(stratum.Matches) + estimateZ*estimateZ/2) / (samples + estimateZ*estimateZ)
+		correction := 0.0
+		if stratum.Contexts > 1 {
+			correction = math.Max(0, (stratum.Contexts-samples)/(stratum.Contexts-1))
+		}
+		variance += stratum.Contexts * stratum.Contexts * adjusted * (1 - adjusted) / (samples + estimateZ*estimateZ) * correction
+	}
+	halfWidth := estimateZ * math.Sqrt(variance)
+	est.Matches = matches
+	est.MatchesLow = math.Max(0, matches-halfWidth)
+	est.MatchesHigh = math.Min(est.Contexts, matches+halfWidth)
+	est.Fraction = est.Matches / est.Contexts
+	est.FractionLow = est.MatchesLow / est.Contexts
+	est.FractionHigh = est.MatchesHigh / est.Contexts
+}
+
+// splitMix64 is a small random source that is cheap to seed, so that every sample can have its own
+type splitMix64 struct {
+	state uint64
+}
+
+func (s *splitMix64) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+func (s *splitMix64) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *splitMix64) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// Number of rounds of the Feistel network of permute
+const feistelRounds = 4
+
+// permute maps i in [0, n) to a distinct number in [0, n), pseudorandomly by key. A Feistel network permutes the
+// numbers of an even number of bits, and the results outside [0, n) are permuted again until they fall inside, which
+// takes fewer than four rounds on average.
+func permute(i uint64, n uint64, key uint64) uint64 {
+	half := uint(bits.Len64(n-1)+1) / 2
+	mask := uint64(1)<<half - 1
+	for {
+		left, right := i>>half, i&mask
+		for round := uint64(0); round < feistelRounds; round++ {
+			f := (&splitMix64{state: key ^ round<<32 ^ right}).Uint64()
+			left, right = right, left^f&mask
+		}
+		i = left<<half | right
+		if i < n {
+			return i
+		}
+	}
+}
+
+// sampleContext overwrites ctx with the superset drawn as sample number index. The draw only depends on the seed and the
+// index, so it is the same in every worker, shard and resumed run. The samples of a stratum are a pseudorandom
+// permutation of its supersets, so that none is drawn twice. Strata with more supersets than a uint64 can count are
+// sampled with replacement instead, where a repeated draw among s samples has a probability below s^2 / 2^65.
+func (scan *Scan) sampleContext(ctx *Context, index uint64) {
+	ctx.Copy(scan.Original)
+	n := len(scan.Flips)
+
+	s := scan.Estimate.Stratum(index)
+	stratum := &scan.Estimate.Strata[s]
+	if stratum.counted {
+		key := (&splitMix64{state: uint64(scan.Cfg.SampleSeed) ^ uint64(s)*0xd1342543de82ef95}).Uint64()
+		rank := permute(scan.Estimate.ordinal(index), stratum.count, key)
+		if stratum.Size == 0 {
+			// Every superset but the original context, by the binary digits of its rank plus one
+			rank++
+			for i, flip := range scan.Flips {
+				ctx.Included[flip.Dimension][flip.Value] = rank&(1<<uint(n-1-i)) != 0
+			}
+			return
+		}
+
+		// The supersets of the size in lexicographic order, where c is the number of ways to add size of the
+		// remaining values
+		size, c := uint64(stratum.Size), stratum.count
+		for i, flip := range scan.Flips {
+			remaining := uint64(n - i)
+			hi, lo := bits.Mul64(c, remaining-size)
+			excluded, _ := bits.Div64(hi, lo, remaining)
+			include := rank >= excluded
+			if include {
+				rank -= excluded
+				hi, lo = bits.Mul64(c, size)
+				c, _ = bits.Div64(hi, lo, remaining)
+				size--
+			} else {
+				c = excluded
+			}
+			ctx.Included[flip.Dimension][flip.Value] = include
+		}
+		return
+	}
+
+	rng := rand.New(&splitMix64{state: uint64(scan.Cfg.SampleSeed) ^ index*0xd1342543de82ef95})
+	size := stratum.Size
+	if size == 0 {
+		// Every attribute value is added with probability 1/2, which draws the original context itself once in 2^n
+		for {
+			added := 0
+			for _, flip := range scan.Flips {
+				include := rng.Int63()&1 != 0
+				ctx.Included[flip.Dimension][flip.Value] = include
+				if include {
+					added++
+				}
+			}
+			if added > 0 {
+				return
+			}
+		}
+	}
+
+	// Selection sampling picks a uniform subset of the given size in one pass
+	for i, flip := range scan.Flips {
+		include := rng.Int63n(int64(n-i)) < int64(size)
+		ctx.Included[flip.Dimension][flip.Value] = include
+		if include {
+			size--
+		}
+	}
+}
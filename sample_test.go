@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPermuteIsBijection(t *testing.T) {
+	for _, n := range []uint64{1, 2, 3, 4, 5, 7, 16, 17, 100, 1000, 4097} {
+		seen := make([]bool, n)
+		for i := uint64(0); i < n; i++ {
+			j := permute(i, n, 12345)
+			if j >= n || seen[j] {
+				t.Fatalf("permutation of %d numbers maps %d to %d, which is out of range or repeated", n, i, j)
+			}
+			seen[j] = true
+		}
+	}
+}
+
+func TestExactBinomial(t *testing.T) {
+	tests := []struct {
+		n, k    uint64
+		c       uint64
+		counted bool
+	}{
+		{5, 0, 1, true},
+		{5, 2, 10, true},
+		{10, 10, 1, true},
+		{64, 32, 1832624140942590534, true},
+		{67, 33, 14226520737620288370, true},
+		{68, 34, 0, false},
+		{1000, 500, 0, false},
+		{1000, 2, 499500, true},
+	}
+	for _, test := range tests {
+		c, counted := exactBinomial(test.n, test.k)
+		if c != test.c || counted != test.counted {
+			t.Errorf("%d choose %d gave %d (counted %t), expected %d (counted %t)", test.n, test.k, c, counted, test.c, test.counted)
+		}
+	}
+}
+
+// flipKey identifies a superset by its flippable values
+func flipKey(scan *Scan, ctx *Context) string {
+	key := make([]byte, len(scan.Flips))
+	for i, flip := range scan.Flips {
+		key[i] = '0'
+		if ctx.Included[flip.Dimension][flip.Value] {
+			key[i] = '1'
+		}
+	}
+	return string(key)
+}
+
+func TestSamplesAreDistinct(t *testing.T) {
+	env := newTestEnv(t, DefaultSyntheticSpec(), testConfig())
+	for _, search := range []string{"sample", "stratified"} {
+		cfg := *env.Cfg
+		cfg.Search = search
+		scan := NewScan(env.Db, env.Lof, &cfg, env.Original, nil, nil)
+		est := scan.Estimate
+		// More samples than supersets, so that every stratum is drawn in full
+		samples := 3 * scan.TotalContexts()
+		drawn := make(map[string]int)
+		ctx := NewContext(env.Db)
+		for index := uint64(0); index < samples; index++ {
+			if !est.Drawn(index) {
+				continue
+			}
+			scan.SetContext(ctx, index)
+			key := flipKey(scan, ctx)
+			if previous, repeated := drawn[key]; repeated {
+				t.Fatalf("%s search draws the same superset as samples %d and %d", search, previous, index)
+			}
+			drawn[key] = int(index)
+			if size := est.Strata[est.Stratum(index)].Size; size > 0 {
+				added := 0
+				for _, c := range key {
+					if c == '1' {
+						added++
+					}
+				}
+				if added != size {
+					t.Fatalf("%s search draws a superset with %d added values as sample %d of the stratum of %d", search, added, index, size)
+				}
+			}
+		}
+		if uint64(len(drawn)) != scan.TotalContexts()-1 {
+			t.Errorf("%s search draws %d distinct supersets, not all %d", search, len(drawn), scan.TotalContexts()-1)
+		}
+
+		// Shards count the samples of their ranges, which add up to the supersets of every stratum
+		var counted uint64
+		for _, shard := range [][2]uint64{{0, samples / 3}, {samples / 3, samples / 2}, {samples / 2, samples}} {
+			est.CountSamples(shard[0], shard[1])
+			for _, stratum := range est.Strata {
+				counted += stratum.Samples
+			}
+		}
+		if counted != uint64(len(drawn)) {
+			t.Errorf("%s search counts %d samples but draws %d supersets", search, counted, len(drawn))
+		}
+	}
+}
+
+func TestCensusEstimateIsExact(t *testing.T) {
+	est := NewEstimate(4, true)
+	est.CountSamples(0, 1000)
+	for i := range est.Strata {
+		est.Strata[i].Matches = uint64(est.Strata[i].Size)
+	}
+	est.Compute()
+	// 1 + 2 + 3 + 4 matches among all 15 supersets
+	if est.Matches != 10 || est.MatchesLow != 10 || est.MatchesHigh != 10 {
+		t.Errorf("a census of the supersets estimates %v matches (95%% CI %v to %v), not exactly 10", est.Matches, est.MatchesLow, est.MatchesHigh)
+	}
+	if math.Abs(est.Fraction-10.0/15) > 1e-12 {
+		t.Errorf("a census of the supersets estimates a fraction of %v, not 10/15", est.Fraction)
+	}
+}
+
+func TestEmptyEstimate(t *testing.T) {
+	for _, stratified := range []bool{false, true} {
+		est := NewEstimate(0, stratified)
+		est.CountSamples(0, 1000)
+		est.Compute()
+		values := []float64{est.Contexts, est.Matches, est.MatchesLow, est.MatchesHigh, est.Fraction, est.FractionLow, est.FractionHigh}
+		for _, value := range values {
+			if value != 0 {
+				t.Errorf("without supersets, the estimate (stratified: %v) is %+v instead of empty", stratified, *est)
+				break
+			}
+		}
+	}
+}
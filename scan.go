@@ -0,0 +1,298 @@
+package main
+
+import (
+	"log"
+	"math/bits"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FlipVar identifies an attribute value that is excluded from the original context and can be added to form a superset
+type FlipVar struct {
+	Dimension int // Index into Context.Included
+	Value     int
+}
+
+// Target is an outlier of the original context that is being explained. It accumulates the number of superset
+// contexts in which it remains an outlier and privately selects one of them.
+type Target struct {
+	Outlier
+	Matches   uint64
+	Mechanism *ExponentialMechanism
+}
+
+func NewTarget(db *Database, outlier Outlier, cfg *Config) *Target {
+	return &Target{
+		Outlier:   outlier,
+		Mechanism: NewExponentialMechanism(db, cfg.Epsilon, Utilities[cfg.Utility]),
+	}
+}
+
+// MatchingContext is a scanned context in which at least one target remains an outlier
+type MatchingContext struct {
+	Context     *Context
+	Index       uint64 // Number of the context in the enumeration, or of the sample
+	PopSize     uint64
+	OutlierList []Outlier
+	TargetList  []int // Indices into the targets of the outliers being explained
+
+	printedNotice chan struct{}
+}
+
+// Scan enumerates every superset of an original context and reports those in which the targets remain outliers.
+// Supersets are numbered by treating the flippable attribute values as the bits of a counter, with the first flippable
+// value as the most significant bit. In Gray code order, the bits of context i are those of the Gray code of i instead,
+// so that consecutive contexts differ by a single attribute value. Context 0 is the original context itself and is never
+// scanned.
+type Scan struct {
+	Db       *Database
+	Lof      *Lof // Nil when the detector needs no neighbors
+	Cfg      *Config
+	Original *Context
+	Flips    []FlipVar
+	Targets  []*Target
+	Out      ResultWriter
+
+	Found uint64 // Number of matching contexts written so far
+
+	// Number of contexts scanned and ruled out by the pruned search in this run
+	Evaluated uint64
+	Skipped   uint64
+
+	// Number of scores that were NaN or infinite, and of contexts that got no scores by reason, in this run
+	NonFinite uint64
+	Unscored  [numSkipReasons]uint64
+
+	// When sampling, the contexts are numbered samples instead of the supersets themselves
+	Estimate *Estimate
+
+	targetIndices map[*Employee]int
+}
+
+func NewScan(db *Database, lof *Lof, cfg *Config, original *Context, targets []*Target, out ResultWriter) *Scan {
+	scan := &Scan{
+		Db:            db,
+		Lof:           lof,
+		Cfg:           cfg,
+		Original:      original,
+		Targets:       targets,
+		Out:           out,
+		targetIndices: make(map[*Employee]int, len(targets)),
+	}
+	for dimension, arr := range original.Included {
+		for value, included := range arr {
+			if !included {
+				scan.Flips = append(scan.Flips, FlipVar{Dimension: dimension, Value: value})
+			}
+		}
+	}
+	for i, target := range targets {
+		scan.targetIndices[target.Employee] = i
+	}
+	switch cfg.Search {
+	case "sample":
+		scan.Estimate = NewEstimate(len(scan.Flips), false)
+	case "stratified":
+		scan.Estimate = NewEstimate(len(scan.Flips), true)
+	}
+	return scan
+}
+
+// TotalContexts is the number of supersets of the original context, including the original context
+func (scan *Scan) TotalContexts() uint64 {
+	return 1 << uint(len(scan.Flips))
+}
+
+// Size is the number of contexts that are numbered for the scan: the supersets of the original context including
+// itself, or the samples
+func (scan *Scan) Size() uint64 {
+	if scan.Estimate != nil {
+		return scan.Cfg.Samples
+	}
+	return scan.TotalContexts()
+}
+
+// ShardRange returns the contexts [first, end) that form shard number shard out of shards. Shards are contiguous runs of
+// the enumeration, so together they cover every context exactly once and shard outputs can be concatenated in order.
+func (scan *Scan) ShardRange(shard uint, shards uint) (first uint64, end uint64) {
+	bound := func(i uint64) uint64 {
+		hi, lo := bits.Mul64(i, scan.Size())
+		quotient, _ := bits.Div64(hi, lo, uint64(shards))
+		return quotient
+	}
+	return bound(uint64(shard)), bound(uint64(shard) + 1)
+}
+
+// Number of consecutive contexts handed to a worker at once. Consecutive contexts are similar, so a worker keeps
+// touching the same records.
+const scanRunLength = 256
+
+// scanRun is the contexts [first, end)
+type scanRun struct {
+	first uint64
+	end   uint64
+}
+
+// SetContext overwrites ctx with the superset numbered index
+func (scan *Scan) SetContext(ctx *Context, index uint64) {
+	if scan.Estimate != nil {
+		scan.sampleContext(ctx, index)
+		return
+	}
+	if scan.Cfg.Enumeration == "gray" {
+		index ^= index >> 1
+	}
+	ctx.Copy(scan.Original)
+	n := len(scan.Flips)
+	for i, flip := range scan.Flips {
+		ctx.Included[flip.Dimension][flip.Value] = index&(1<<uint(n-1-i)) != 0
+	}
+}
+
+// Run scans the contexts numbered from first up to (but excluding) end. Every checkpointInterval (if positive), all work in
+// flight is completed and checkpoint is called with the number of the next context to scan; at that point every
+// earlier context has been written out and Found and Targets are up to date.
+func (scan *Scan) Run(lg *log.Logger, first uint64, end uint64, checkpointInterval time.Duration, checkpoint func(next uint64)) {
+	db := scan.Db
+	printFrequency := time.Duration(scan.Cfg.PrintFrequency)
+	workerCount := runtime.NumCPU()
+	if first < 1 && scan.Estimate == nil {
+		// The original context is always skipped
+		first = 1
+	}
+
+	workChan := make(chan scanRun)
+	matchingContextChan := make(chan *MatchingContext)
+	finishedChan := make(chan struct{})
+	var inFlight sync.WaitGroup
+	for worker := 0; worker < workerCount; worker++ {
+		go func() {
+			defer func() { finishedChan <- struct{}{} }()
+
+			// Thread local storage that gets reused between contexts under analysis
+			detector := NewDetector(scan.Cfg, db, scan.Lof)
+			im := NewInclusionMask(db)
+			workCtx := NewContext(db)
+			match := &MatchingContext{
+				Context:       workCtx,
+				printedNotice: make(chan struct{}),
+			}
+
+			for {
+				// Get a new run of contexts to analyze
+				run, more := <-workChan
+				if !more {
+					break
+				}
+				for index := run.first; index < run.end; index++ {
+					if scan.Estimate != nil && !scan.Estimate.Drawn(index) {
+						continue
+					}
+					scan.SetContext(workCtx, index)
+					match.Index = index
+
+					// Gather a list of all outliers in this sub-population
+					match.OutlierList = match.OutlierList[:0]
+					match.TargetList = match.TargetList[:0]
+					handler := func(employee *Employee, score float64) bool {
+						if target, isTarget := scan.targetIndices[employee]; isTarget {
+							match.TargetList = append(match.TargetList, target)
+						}
+						match.OutlierList = append(match.OutlierList, Outlier{Employee: employee, Score: score})
+						return true
+					}
+					FilterContext(db, im, workCtx)
+					if im.Count >= scan.Cfg.MinPopulationSize {
+						detector.FindOutliers(im, handler)
+					} else {
+						detector.Stats().Skips[SkipBelowMinPopulation]++
+					}
+					match.PopSize = im.Count
+
+					// If any original outlier appears, send this context for printing
+					if len(match.TargetList) > 0 {
+						matchingContextChan <- match
+						// Wait until it gets printed before processing more work
+						// This prevents us from clobbering the outlier list buffer while it is being printed
+						<-match.printedNotice
+					}
+				}
+				inFlight.Done()
+			}
+			stats := detector.Stats()
+			atomic.AddUint64(&scan.NonFinite, stats.NonFinite)
+			for reason, count := range stats.Skips {
+				atomic.AddUint64(&scan.Unscored[reason], count)
+			}
+		}()
+	}
+
+	// Goroutine for logging results
+	go func() {
+		defer func() { finishedChan <- struct{}{} }()
+		for {
+			match, more := <-matchingContextChan
+			if !more {
+				break
+			}
+			scan.Out.WriteMatch(match, scan.Targets)
+			scan.Found++
+			if scan.Estimate != nil {
+				scan.Estimate.Strata[scan.Estimate.Stratum(match.Index)].Matches++
+			}
+			for _, target := range match.TargetList {
+				scan.Targets[target].Matches++
+				scan.Targets[target].Mechanism.Offer(match.Context, match.PopSize)
+			}
+
+			// Wake up the worker once more
+			match.printedNotice <- struct{}{}
+		}
+	}()
+	CleanRam(lg)
+
+	// Enumerate all possible supersets by counting through the flippable attribute values
+	lastPrint := time.Now()
+	lastCheckpoint := time.Now()
+	emit := func(run scanRun, skip bool) {
+		if skip {
+			scan.Skipped += run.end - run.first
+		} else {
+			scan.Evaluated += run.end - run.first
+			inFlight.Add(1)
+			workChan <- run
+		}
+		next := run.end
+
+		if time.Since(lastPrint) >= printFrequency {
+			done := next - first
+			lg.Printf("Processed %d / %d contexts (%.2f%%). %s\n", done, end-first, float64(done)/float64(end-first)*100.0, RamStats())
+			lastPrint = time.Now()
+		}
+		if checkpointInterval > 0 && time.Since(lastCheckpoint) >= checkpointInterval {
+			inFlight.Wait()
+			checkpoint(next)
+			lastCheckpoint = time.Now()
+		}
+	}
+	if scan.Cfg.Search == "pruned" {
+		NewPruner(scan).Runs(first, end, emit)
+	} else {
+		for next := first; next < end; {
+			run := scanRun{first: next, end: end}
+			if end-next > scanRunLength {
+				run.end = next + scanRunLength
+			}
+			emit(run, false)
+			next = run.end
+		}
+	}
+	close(workChan)
+	for worker := 0; worker < workerCount; worker++ {
+		<-finishedChan
+	}
+	close(matchingContextChan)
+	<-finishedChan
+}
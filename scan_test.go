@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+)
+
+func TestScanMatchesOracle(t *testing.T) {
+	forEachSeed(t, func(t *testing.T, env *testEnv) {
+		targets := env.targets()
+		if len(targets) == 0 {
+			t.Fatal("the original context has no outliers")
+		}
+		out := &collectingWriter{}
+		scan := NewScan(env.Db, env.Lof, env.Cfg, env.Original, targets, out)
+		// The oracle is slow, so only the first contexts are compared
+		end := uint64(100)
+		scan.Run(log.New(io.Discard, "", 0), 0, end, 0, nil)
+		found := make(map[uint64]bool, len(out.indices))
+		for _, index := range out.indices {
+			found[index] = true
+		}
+
+		lof := env.Lof
+		minReachability := oracleMinReachability(env.Db, lof.Metric)
+		im := NewInclusionMask(env.Db)
+		ctx := NewContext(env.Db)
+		for index := uint64(1); index < end; index++ {
+			scan.SetContext(ctx, index)
+			FilterContext(env.Db, im, ctx)
+			match := false
+			if im.Count >= env.Cfg.MinPopulationSize && !(lof.SkipSmall && im.Count <= lof.K) {
+				subset := members(im, len(env.Db.Employees))
+				oracleScores := oracleLof(env.Db, lof.Metric, lof.K, lof.TieInclusive, minReachability, subset)
+				for p, i := range subset {
+					if _, isTarget := scan.targetIndices[env.Db.Employees[i]]; isTarget {
+						match = match || oracleScores[p] >= lof.Threshold
+					}
+				}
+			}
+			if match != found[index] {
+				t.Fatalf("context %d is a match for the oracle (%t) but not for the scan (%t)", index, match, found[index])
+			}
+		}
+	})
+}
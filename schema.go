@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Schema describes the columns of the input CSV file. Columns are identified by their names in the CSV header, which
+// also name the attributes everywhere else (the original context, metric weights, and the output).
+type Schema struct {
+	Id         string            `json:"id"`         // Column of the unique numeric record identifiers
+	Attributes []SchemaAttribute `json:"attributes"` // Categorical columns that contexts filter on
+	Distances  []string          `json:"distances"`  // Numeric columns that the metrics measure
+}
+
+// SchemaAttribute is a categorical column that contexts filter on
+type SchemaAttribute struct {
+	Column     string `json:"column"`
+	Numeric    bool   `json:"numeric"`    // Values are numbers, which sort numerically and differ by an amount
+	MinRecords uint   `json:"minRecords"` // Values with fewer records are removed by initial filtering
+}
+
+// DefaultSchema describes the Ontario public sector salary disclosure files
+func DefaultSchema() *Schema {
+	return &Schema{
+		Id: "",
+		Attributes: []SchemaAttribute{
+			{Column: "Employer", MinRecords: 3000},
+			{Column: "Job Title", MinRecords: 3000},
+			{Column: "Calendar Year", Numeric: true},
+		},
+		Distances: []string{"Salary Paid"},
+	}
+}
+
+func LoadSchema(fileName string) (*Schema, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to open schema file: %s", err))
+	}
+	defer f.Close()
+
+	schema := &Schema{}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(schema); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to parse schema file \"%s\": %s", fileName, err))
+	}
+	return schema, nil
+}
+
+func (schema *Schema) Validate() error {
+	if len(schema.Attributes) == 0 {
+		return errors.New("schema has no attributes")
+	}
+	if len(schema.Distances) == 0 {
+		return errors.New("schema has no distance columns")
+	}
+	columns := map[string]bool{schema.Id: true}
+	for _, attribute := range schema.Attributes {
+		if columns[attribute.Column] {
+			return errors.New(fmt.Sprintf("schema uses column \"%s\" more than once", attribute.Column))
+		}
+		columns[attribute.Column] = true
+	}
+	for _, column := range schema.Distances {
+		if columns[column] {
+			return errors.New(fmt.Sprintf("schema uses column \"%s\" more than once", column))
+		}
+		columns[column] = true
+	}
+	return nil
+}
+
+// Attribute returns the index of the attribute in the named column, or -1
+func (schema *Schema) Attribute(column string) int {
+	for i, attribute := range schema.Attributes {
+		if attribute.Column == column {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+// SyntheticSpec describes a random database in the layout of the default schema. Salaries are drawn around a mean that
+// is offset per employer and per job title and grows every year, and a few planted outliers earn far more.
+type SyntheticSpec struct {
+	Records   int
+	Employers int
+	JobTitles int
+	Years     int
+	FirstYear int
+
+	Distribution   string  // Of the salaries around their mean: "normal" or "lognormal"
+	Salary         float64 // Mean salary
+	Spread         float64 // Standard deviation of the salaries around their mean
+	EmployerSpread float64 // Standard deviation of the offsets of the employers
+	JobTitleSpread float64 // Standard deviation of the offsets of the job titles
+	Growth         float64 // Relative growth of the mean salary per year
+	Step           float64 // Salaries are rounded to multiples of the step (or to cents when zero), which creates ties
+
+	Outliers     int     // Number of planted outliers
+	OutlierShift float64 // Planted outliers earn this many standard deviations more than their mean
+
+	Seed int64
+}
+
+// SyntheticSchema describes the synthetic databases. Like the default schema, it removes the rarest employers and job
+// titles, but at a scale that keeps all of those of DefaultSyntheticSpec.
+func SyntheticSchema() *Schema {
+	schema := DefaultSchema()
+	for i := range schema.Attributes {
+		if !schema.Attributes[i].Numeric {
+			schema.Attributes[i].MinRecords = 10
+		}
+	}
+	return schema
+}
+
+func DefaultSyntheticSpec() *SyntheticSpec {
+	return &SyntheticSpec{
+		Records:        600,
+		Employers:      8,
+		JobTitles:      6,
+		Years:          6,
+		FirstYear:      2010,
+		Distribution:   "normal",
+		Salary:         100000,
+		Spread:         8000,
+		EmployerSpread: 5000,
+		JobTitleSpread: 10000,
+		Growth:         0.02,
+		Outliers:       5,
+		OutlierShift:   10,
+		Seed:           1,
+	}
+}
+
+func (spec *SyntheticSpec) Validate() error {
+	if spec.Records < 1 || spec.Employers < 1 || spec.JobTitles < 1 || spec.Years < 1 {
+		return errors.New("there must be at least one record, employer, job title and calendar year")
+	}
+	if spec.Distribution != "normal" && spec.Distribution != "lognormal" {
+		return errors.New(fmt.Sprintf("unknown salary distribution \"%s\"", spec.Distribution))
+	}
+	if spec.Spread < 0 || spec.EmployerSpread < 0 || spec.JobTitleSpread < 0 || spec.Step < 0 {
+		return errors.New("spreads and the step cannot be negative")
+	}
+	if spec.Outliers < 0 || spec.Outliers > spec.Records {
+		return errors.New(fmt.Sprintf("cannot plant %d outliers among %d records", spec.Outliers, spec.Records))
+	}
+	return nil
+}
+
+// Generate writes a random database as CSV and returns the IDs of the planted outliers
+func (spec *SyntheticSpec) Generate(w io.Writer) ([]uint64, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	rnd := rand.New(rand.NewSource(spec.Seed))
+	employerOffsets := make([]float64, spec.Employers)
+	for i := range employerOffsets {
+		employerOffsets[i] = rnd.NormFloat64() * spec.EmployerSpread
+	}
+	jobTitleOffsets := make([]float64, spec.JobTitles)
+	for i := range jobTitleOffsets {
+		jobTitleOffsets[i] = rnd.NormFloat64() * spec.JobTitleSpread
+	}
+	planted := make(map[int]bool, spec.Outliers)
+	for _, i := range rnd.Perm(spec.Records)[:spec.Outliers] {
+		planted[i] = true
+	}
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"", "Employer", "Job Title", "Salary Paid", "Calendar Year"})
+	var plantedIds []uint64
+	for i := 0; i < spec.Records; i++ {
+		employer := rnd.Intn(spec.Employers)
+		jobTitle := rnd.Intn(spec.JobTitles)
+		year := rnd.Intn(spec.Years)
+
+		mean := (spec.Salary + employerOffsets[employer] + jobTitleOffsets[jobTitle]) * math.Pow(1+spec.Growth, float64(year))
+		deviation := rnd.NormFloat64()
+		if planted[i] {
+			deviation = spec.OutlierShift
+			plantedIds = append(plantedIds, uint64(i+1))
+		}
+		var salary float64
+		switch spec.Distribution {
+		case "normal":
+			salary = mean + deviation*spec.Spread
+		case "lognormal":
+			// Same mean and standard deviation as the normal distribution, but skewed
+			sigma := math.Sqrt(math.Log(1 + spec.Spread*spec.Spread/(mean*mean)))
+			salary = mean * math.Exp(deviation*sigma-sigma*sigma/2)
+		}
+		if spec.Step > 0 {
+			salary = math.Round(salary/spec.Step) * spec.Step
+		}
+		if salary < 0 {
+			salary = 0
+		}
+
+		out.Write([]string{
+			strconv.Itoa(i + 1),
+			fmt.Sprintf("Emp %d", employer),
+			fmt.Sprintf("Title %d", jobTitle),
+			fmt.Sprintf("$%.2f", salary),
+			strconv.Itoa(spec.FirstYear + year),
+		})
+	}
+	out.Flush()
+	return plantedIds, out.Error()
+}